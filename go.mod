@@ -6,6 +6,7 @@ toolchain go1.24.1
 
 require (
 	github.com/stretchr/testify v1.11.1
+	golang.org/x/sync v0.18.0
 	gopkg.in/go-playground/validator.v9 v9.31.0
 	software.sslmate.com/src/go-pkcs12 v0.7.3
 )
@@ -0,0 +1,49 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// AnimateQRCode sends the content of the animated QR code of the order to the function every second, as the seconds
+// from the start of the order change, until the QR code can no longer be scanned, see QRCodeLifetime.
+//
+// It returns nil once the QR code has expired, ErrNoQRCode for orders started without a QR code, the error of the
+// context if it is done first, such as once the order has been scanned, and the error of the function if it fails.
+func AnimateQRCode(
+	ctx context.Context,
+	generator QRCodeContentGenerator,
+	order *Order,
+	send func(content string) error,
+) error {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err() // nolint:wrapcheck
+		case <-timer.C:
+		}
+
+		now := time.Now()
+
+		content, err := order.QRCodeContent(generator, now)
+		if errors.Is(err, ErrQRCodeExpired) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := send(content); err != nil {
+			return err
+		}
+
+		// The content changes when the next whole second since the start of the order begins
+		elapsed := max(now.Sub(order.StartedAt).Truncate(time.Second), 0)
+		timer.Reset(time.Until(order.StartedAt.Add(elapsed + time.Second)))
+	}
+}
@@ -5,9 +5,11 @@ import (
 	"context"
 	"crypto/hmac"
 	"crypto/sha256"
+	"crypto/x509"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/e-identification/bankid-go/pkg/configuration"
 	"github.com/e-identification/bankid-go/pkg/internal"
@@ -93,11 +95,20 @@ type BankIDClient struct {
 	validator     *playground.Validate
 	configuration *configuration.Configuration
 	client        http.Client
+	certificates  configuration.CertificateSource
+	health        *healthCache
+	orders        *orderTracker
 }
 
 // NewBankIDClient returns a new instance of 'BankIDClient'.
 func NewBankIDClient(configuration *configuration.Configuration) (*BankIDClient, error) {
-	client, err := http.NewClient(configuration)
+	// The source is loaded once, so the certificate checked is the certificate presented in the handshakes
+	certificates, err := configuration.ClientCertificateSource()
+	if err != nil {
+		return nil, fmt.Errorf("unable to load client certificate. %w", err)
+	}
+
+	client, err := http.NewClientWithCertificateSource(configuration, certificates)
 	if err != nil {
 		return nil, fmt.Errorf("unable to initialize http client. %w", err)
 	}
@@ -107,7 +118,16 @@ func NewBankIDClient(configuration *configuration.Configuration) (*BankIDClient,
 		return nil, fmt.Errorf("unable to initialize validator. %w", err)
 	}
 
-	return &BankIDClient{validator: validator, configuration: configuration, client: client}, nil
+	instance := &BankIDClient{
+		validator: validator, configuration: configuration, client: client, certificates: certificates,
+		health: &healthCache{}, orders: newOrderTracker(),
+	}
+
+	if err := instance.checkCertificate(); err != nil {
+		return nil, err
+	}
+
+	return instance, nil
 }
 
 // Authenticate - Initiates an authentication order.
@@ -122,9 +142,7 @@ func (b BankIDClient) Authenticate(
 	context context.Context,
 	payload *payload.AuthenticationPayload,
 ) (*response.AuthenticateResponse, error) {
-	request := &http.Request{
-		URI: "auth", Payload: payload, Response: &response.AuthenticateResponse{}, ErrorResponse: &APIError{},
-	}
+	request := authEndpoint.request(payload, &response.AuthenticateResponse{})
 
 	httpResponse, err := b.call(context, request)
 	if err != nil {
@@ -146,9 +164,7 @@ func (b BankIDClient) PhoneAuthenticate(
 	context context.Context,
 	payload *payload.PhoneAuthenticationPayload,
 ) (*response.PhoneAuthenticateResponse, error) {
-	request := &http.Request{
-		URI: "phone/auth", Payload: payload, Response: &response.PhoneAuthenticateResponse{}, ErrorResponse: &APIError{},
-	}
+	request := phoneAuthEndpoint.request(payload, &response.PhoneAuthenticateResponse{})
 
 	httpResponse, err := b.call(context, request)
 	if err != nil {
@@ -165,7 +181,7 @@ func (b BankIDClient) PhoneAuthenticate(
 //
 // It returns APIError for errors that originates from the BankID RP API, ValidationError if incorrect payload or std errors such as net.OpError on network errors.
 func (b BankIDClient) Sign(context context.Context, payload *payload.SignPayload) (*response.SignResponse, error) {
-	request := &http.Request{URI: "sign", Payload: payload, Response: &response.SignResponse{}, ErrorResponse: &APIError{}}
+	request := signEndpoint.request(payload, &response.SignResponse{})
 
 	httpResponse, err := b.call(context, request)
 	if err != nil {
@@ -186,9 +202,7 @@ func (b BankIDClient) PhoneSign(
 	context context.Context,
 	payload *payload.PhoneSignPayload,
 ) (*response.PhoneSignResponse, error) {
-	request := &http.Request{
-		URI: "phone/sign", Payload: payload, Response: &response.PhoneSignResponse{}, ErrorResponse: &APIError{},
-	}
+	request := phoneSignEndpoint.request(payload, &response.PhoneSignResponse{})
 
 	httpResponse, err := b.call(context, request)
 	if err != nil {
@@ -209,9 +223,7 @@ func (b BankIDClient) Collect(
 	context context.Context,
 	payload *payload.CollectPayload,
 ) (*response.CollectResponse, error) {
-	request := &http.Request{
-		URI: "collect", Payload: payload, Response: &response.CollectResponse{}, ErrorResponse: &APIError{},
-	}
+	request := collectEndpoint.request(payload, &response.CollectResponse{})
 
 	httpResponse, err := b.call(context, request)
 	if err != nil {
@@ -231,9 +243,7 @@ func (b BankIDClient) Cancel(
 	context context.Context,
 	payload *payload.CancelPayload,
 ) (*response.CancelResponse, error) {
-	request := &http.Request{
-		URI: "cancel", Payload: payload, Response: &response.CancelResponse{}, ErrorResponse: &APIError{},
-	}
+	request := cancelEndpoint.request(payload, &response.CancelResponse{})
 
 	httpResult, err := b.call(context, request)
 	if err != nil {
@@ -257,8 +267,66 @@ func (b BankIDClient) QRCodeContent(qrStartToken, qrStartSecret string, seconds
 	return fmt.Sprintf("bankid.%s.%d.%s", qrStartToken, seconds, hex.EncodeToString(hash.Sum(nil))), nil
 }
 
+// CertificateInfo returns the metadata of the client certificate currently presented to the BankID RP API.
+func (b BankIDClient) CertificateInfo() (*configuration.CertificateInfo, error) {
+	leaf, err := b.leafCertificate()
+	if err != nil {
+		return nil, err
+	}
+
+	return configuration.NewCertificateInfo(leaf), nil
+}
+
+// Close closes the idle connections of the client and stops following the reloads of its client certificate. The
+// outstanding orders are not cancelled.
+func (b BankIDClient) Close() {
+	if b.client != nil {
+		b.client.Close()
+	}
+}
+
+// checkCertificate checks the client certificate according to the expiry policy, refusing an expired or not yet
+// valid certificate if the policy says so.
+func (b BankIDClient) checkCertificate() error {
+	policy := b.configuration.ExpiryPolicy
+	if policy == nil {
+		return nil
+	}
+
+	leaf, err := b.leafCertificate()
+	if err != nil {
+		return err
+	}
+
+	if err := policy.Check(leaf, time.Now()); err != nil && policy.RefuseInvalid {
+		return fmt.Errorf("refusing to use the client certificate. %w", err)
+	}
+
+	return nil
+}
+
+func (b BankIDClient) leafCertificate() (*x509.Certificate, error) {
+	certificate, err := b.certificates.ClientCertificate()
+	if err != nil {
+		return nil, fmt.Errorf("unable to load client certificate. %w", err)
+	}
+
+	leaf, err := configuration.LeafCertificate(certificate)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse client certificate. %w", err)
+	}
+
+	return leaf, nil
+}
+
 // call validates the prerequisites of the requests and invokes the REST API method.
 func (b BankIDClient) call(context context.Context, request *http.Request) (http.Response, error) {
+	// Fail fast if the endpoint is not available in the version of the API
+	if environment := b.configuration.Environment; !environment.Supports(request.MinVersion) {
+		return nil, fmt.Errorf("%w. %s requires %s, the environment uses %s", ErrUnsupportedAPIVersion, request.URI,
+			request.MinVersion, environment.APIVersion)
+	}
+
 	// Validate the integrity of the Payload
 	if err := b.validator.Struct(request.Payload); err != nil {
 		var validationErrors playground.ValidationErrors
@@ -271,5 +339,17 @@ func (b BankIDClient) call(context context.Context, request *http.Request) (http
 		return nil, fmt.Errorf("unable to validate the request payload. %w", err)
 	}
 
-	return b.client.Call(context, request) // nolint: wrapcheck
+	if b.orders == nil {
+		return b.client.Call(context, request) // nolint: wrapcheck
+	}
+
+	if err := b.orders.begin(request); err != nil {
+		return nil, err
+	}
+
+	httpResponse, err := b.client.Call(context, request)
+
+	b.orders.end(request, httpResponse)
+
+	return httpResponse, err // nolint: wrapcheck
 }
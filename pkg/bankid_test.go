@@ -3,6 +3,9 @@ package pkg
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -12,11 +15,15 @@ import (
 	"os"
 	"path"
 	"runtime"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/e-identification/bankid-go/pkg/configuration"
+	"github.com/e-identification/bankid-go/pkg/fault"
 	bankIdHttp "github.com/e-identification/bankid-go/pkg/internal/http"
 	"github.com/e-identification/bankid-go/pkg/payload"
+	"github.com/e-identification/bankid-go/pkg/response"
 
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/go-playground/validator.v9"
@@ -84,6 +91,8 @@ func TestSign(t *testing.T) {
 	if response.AutoStartToken != "7c40b5c9-fa74-49cf-b98c-bfe651f9a7c6" {
 		t.Error("Got wrong auto start token")
 	}
+
+	assert.False(t, response.TimeOfResponse.IsZero())
 }
 
 func TestPhoneSign(t *testing.T) {
@@ -202,16 +211,357 @@ func TestWith403ForbiddenResponse(t *testing.T) {
 		"<center><h1>403 Forbidden</h1></center>\n    <hr>\n    <center>nginx</center>\n</body>\n</html>", err.Error())
 }
 
+func TestWithInjectedMaintenance(t *testing.T) {
+	bankID, teardown := testBankID(fileToResponseHandler(t, "resource/test_data/collect_response.json"),
+		fault.WithRule(fault.Rule{Endpoint: "collect", Fault: fault.Maintenance()}))
+	defer teardown()
+
+	_, err := bankID.Collect(context.Background(), &payload.CollectPayload{OrderRef: ""})
+
+	var apiError *APIError
+	if !errors.As(err, &apiError) {
+		t.Fatalf("expected APIError, got %v", err)
+	}
+
+	assert.Equal(t, "maintenance", apiError.ErrorCode)
+}
+
+func TestWithInjectedTruncatedResponse(t *testing.T) {
+	bankID, teardown := testBankID(fileToResponseHandler(t, "resource/test_data/collect_response.json"),
+		fault.WithRule(fault.Rule{Endpoint: "collect", Fault: fault.Truncate(20)}))
+	defer teardown()
+
+	response, err := bankID.Collect(context.Background(), &payload.CollectPayload{OrderRef: ""})
+	if response != nil {
+		t.Fatal("Got non-nil response")
+	}
+
+	assert.ErrorContains(t, err, "unable to decode response")
+}
+
+func TestCertificateInfo(t *testing.T) {
+	bankID, teardown := testBankID(stringToResponseHandler(t, "{}"))
+	defer teardown()
+
+	info, err := bankID.CertificateInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, "5566304928", info.OrganisationNumber)
+	assert.Equal(t, "1BCFE6681C8ECD5E", info.SerialNumber)
+	assert.Contains(t, info.Subject, "CN=FP Testcert 5")
+	assert.Contains(t, info.Issuer, "CN=Testbank A RP CA v1 for BankID Test")
+	assert.Equal(t, time.Date(2029, 5, 28, 21, 59, 59, 0, time.UTC), info.NotAfter)
+}
+
+func TestCertificateExpiryPolicyAtStartup(t *testing.T) {
+	var warnings []configuration.CertificateWarning
+
+	policy := &configuration.ExpiryPolicy{
+		WarningWindow: 100 * 365 * 24 * time.Hour,
+		OnWarning: func(warning configuration.CertificateWarning) {
+			warnings = append(warnings, warning)
+		},
+		RefuseInvalid: true,
+	}
+
+	_, err := NewBankIDClient(configuration.NewConfiguration(configuration.TestEnvironment,
+		&configuration.Pkcs12{Content: loadFile(getResourcePath("certificates/test.p12")), Password: "qwerty123"},
+		configuration.WithExpiryPolicy(policy)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Len(t, warnings, 1)
+	assert.ErrorIs(t, warnings[0].Reason, configuration.ErrCertificateExpiring)
+}
+
+func TestPreflight(t *testing.T) {
+	bankID, teardown := testBankID(invalidParametersHandler(t))
+	defer teardown()
+
+	report := bankID.Preflight(context.Background())
+	if !report.OK() {
+		t.Fatal(report.Err())
+	}
+
+	assert.Equal(t, configuration.TestEnvironment.BaseURL, report.BaseURL)
+	assert.Equal(t, "5566304928", report.Certificate.OrganisationNumber)
+	assert.Len(t, report.Checks, 4)
+
+	for _, check := range report.Checks {
+		assert.True(t, check.Passed(), check.Name)
+	}
+}
+
+func TestPreflightWithTestCertificateInProduction(t *testing.T) {
+	bankID, teardown := testBankIDWithConfiguration(configuration.NewConfiguration(configuration.ProductionEnvironment,
+		&configuration.Pkcs12{Content: loadFile(getResourcePath("certificates/test.p12")), Password: "qwerty123"}),
+		invalidParametersHandler(t))
+	defer teardown()
+
+	report := bankID.Preflight(context.Background())
+
+	assert.False(t, report.OK())
+	assert.ErrorIs(t, report.Err(), ErrEnvironmentMismatch)
+
+	environmentCheck, _ := report.Check(PreflightCheckEnvironment)
+	assert.ErrorIs(t, environmentCheck.Err, ErrEnvironmentMismatch)
+}
+
+func TestPreflightEnvironmentOfUnknownIssuer(t *testing.T) {
+	bankID, err := NewBankIDClient(configuration.NewConfiguration(configuration.ProductionEnvironment,
+		&configuration.Pkcs12{Content: loadFile(getResourcePath("certificates/test.p12")), Password: "qwerty123"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The environment of a certificate is not guessed from the name of its issuer
+	skipped, err := bankID.checkEnvironment(&x509.Certificate{Issuer: pkix.Name{CommonName: "Test RP CA v2"}})
+	assert.True(t, skipped)
+	assert.NoError(t, err)
+}
+
+func TestPreflightWithUnauthorizedCertificate(t *testing.T) {
+	bankID, teardown := testBankID(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusForbidden)
+		fileToResponseHandler(t, "resource/test_data/403_forbidden_response.html")(writer, request)
+	})
+	defer teardown()
+
+	report := bankID.Preflight(context.Background())
+
+	assert.False(t, report.OK())
+	handshakeCheck, _ := report.Check(PreflightCheckHandshake)
+	assert.True(t, handshakeCheck.Passed())
+
+	authorizationCheck, ok := report.Check(PreflightCheckAuthorization)
+	assert.True(t, ok)
+	assert.ErrorContains(t, authorizationCheck.Err, "403 Forbidden")
+}
+
+func TestHealthIsCached(t *testing.T) {
+	var calls atomic.Int32
+
+	bankID, teardown := testBankID(func(writer http.ResponseWriter, request *http.Request) {
+		calls.Add(1)
+		invalidParametersHandler(t)(writer, request)
+	})
+	defer teardown()
+
+	status := bankID.Health(context.Background())
+	if !status.Healthy {
+		t.Fatal(status.Err)
+	}
+
+	assert.Positive(t, status.Latency)
+	assert.Equal(t, "5566304928", status.Certificate.OrganisationNumber)
+
+	bankID.Health(context.Background())
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestHealthHandler(t *testing.T) {
+	bankID, teardown := testBankID(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusForbidden)
+		fileToResponseHandler(t, "resource/test_data/403_forbidden_response.html")(writer, request)
+	})
+	defer teardown()
+
+	recorder := httptest.NewRecorder()
+	bankID.HealthHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	assert.Contains(t, recorder.Body.String(), `"healthy":false`)
+	assert.Contains(t, recorder.Body.String(), `"circuit":"closed","consecutiveFailures":1`)
+	assert.Contains(t, recorder.Body.String(), `"certificateNotAfter":"2029-05-28T21:59:59Z"`)
+}
+
+func TestHealthCircuitOpens(t *testing.T) {
+	healthy := atomic.Bool{}
+
+	bankID, teardown := testBankID(func(writer http.ResponseWriter, request *http.Request) {
+		if healthy.Load() {
+			invalidParametersHandler(t)(writer, request)
+
+			return
+		}
+
+		writer.WriteHeader(http.StatusForbidden)
+	})
+	defer teardown()
+
+	bankID.configuration.HealthCacheInterval = 0
+
+	for failures := 1; failures < healthCircuitThreshold; failures++ {
+		status := bankID.Health(context.Background())
+		assert.Equal(t, failures, status.ConsecutiveFailures)
+		assert.Equal(t, CircuitClosed, status.Circuit)
+	}
+
+	status := bankID.Health(context.Background())
+	assert.Equal(t, healthCircuitThreshold, status.ConsecutiveFailures)
+	assert.Equal(t, CircuitOpen, status.Circuit)
+
+	healthy.Store(true)
+
+	status = bankID.Health(context.Background())
+	assert.True(t, status.Healthy)
+	assert.Zero(t, status.ConsecutiveFailures)
+	assert.Equal(t, CircuitClosed, status.Circuit)
+}
+
+func TestHealthSharesProbe(t *testing.T) {
+	var calls atomic.Int32
+
+	release := make(chan struct{})
+
+	bankID, teardown := testBankID(func(writer http.ResponseWriter, request *http.Request) {
+		calls.Add(1)
+		<-release
+		invalidParametersHandler(t)(writer, request)
+	})
+	defer teardown()
+
+	statuses := make(chan HealthStatus, 5)
+
+	for range cap(statuses) {
+		go func() {
+			statuses <- bankID.Health(context.Background())
+		}()
+	}
+
+	assert.Eventually(t, func() bool { return calls.Load() == 1 }, time.Second, time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	for range cap(statuses) {
+		assert.True(t, (<-statuses).Healthy)
+	}
+
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestHealthProbeOutlivesCancelledCheck(t *testing.T) {
+	var calls atomic.Int32
+
+	release := make(chan struct{})
+
+	bankID, teardown := testBankID(func(writer http.ResponseWriter, request *http.Request) {
+		calls.Add(1)
+		<-release
+		invalidParametersHandler(t)(writer, request)
+	})
+	defer teardown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := make(chan HealthStatus)
+
+	go func() {
+		cancelled <- bankID.Health(ctx)
+	}()
+
+	assert.Eventually(t, func() bool { return calls.Load() == 1 }, time.Second, time.Millisecond)
+	cancel()
+
+	// The cancelled check neither fails the probe nor counts as a failure
+	status := <-cancelled
+	assert.ErrorIs(t, status.Err, context.Canceled)
+	assert.Zero(t, status.ConsecutiveFailures)
+
+	close(release)
+
+	status = bankID.Health(context.Background())
+	assert.True(t, status.Healthy)
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestWarmUp(t *testing.T) {
+	bankID, teardown := testBankID(invalidParametersHandler(t))
+	defer teardown()
+
+	if err := bankID.WarmUp(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _ = bankID.Collect(context.Background(), &payload.CollectPayload{OrderRef: probeOrderRef})
+
+	stats := bankID.TransportStats()
+	assert.Equal(t, int64(2), stats.Requests)
+	assert.Equal(t, int64(1), stats.NewConnections)
+	assert.Equal(t, int64(1), stats.ReusedConnections)
+	assert.Equal(t, int64(1), stats.Handshakes)
+}
+
+func TestWarmUpWithUnauthorizedCertificate(t *testing.T) {
+	bankID, teardown := testBankID(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusForbidden)
+		fileToResponseHandler(t, "resource/test_data/403_forbidden_response.html")(writer, request)
+	})
+	defer teardown()
+
+	err := bankID.WarmUp(context.Background())
+
+	assert.ErrorContains(t, err, "unable to warm up the connections")
+	assert.ErrorContains(t, err, "403 Forbidden")
+}
+
+func TestRequestUsesAPIVersionOfEnvironment(t *testing.T) {
+	environment := configuration.TestEnvironment.WithAPIVersion(configuration.APIVersion{Major: 6, Minor: 1})
+
+	bankID, teardown := testBankIDWithConfiguration(configuration.NewConfiguration(environment,
+		&configuration.Pkcs12{Content: loadFile(getResourcePath("certificates/test.p12")), Password: "qwerty123"}),
+		func(writer http.ResponseWriter, request *http.Request) {
+			assert.Equal(t, "/rp/v6.1/cancel", request.URL.Path)
+			stringToResponseHandler(t, "{}")(writer, request)
+		})
+	defer teardown()
+
+	if _, err := bankID.Cancel(context.Background(), &payload.CancelPayload{OrderRef: ""}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnsupportedAPIVersion(t *testing.T) {
+	bankID, teardown := testBankID(func(http.ResponseWriter, *http.Request) {
+		t.Error("the request should not be sent")
+	})
+	defer teardown()
+
+	future := endpoint{uri: "future", minVersion: configuration.APIVersion{Major: 6, Minor: 1}}
+
+	_, err := bankID.call(context.Background(), future.request(&payload.CollectPayload{}, &response.CollectResponse{}))
+
+	assert.ErrorIs(t, err, ErrUnsupportedAPIVersion)
+	assert.ErrorContains(t, err, "future requires v6.1, the environment uses v6.0")
+}
+
 // Returns a bankID whose requests will always return
-// a response configured by the handler.
-func testBankID(handler http.HandlerFunc) (*BankIDClient, func()) {
+// a response configured by the handler, with faults injected according to the fault options.
+func testBankID(handler http.HandlerFunc, faults ...fault.Option) (*BankIDClient, func()) {
 	clientConfiguration := configuration.NewConfiguration(configuration.TestEnvironment,
 		&configuration.Pkcs12{Content: loadFile(getResourcePath("certificates/test.p12")), Password: "qwerty123"})
 
+	return testBankIDWithConfiguration(clientConfiguration, handler, faults...)
+}
+
+// Returns a bankID using the configuration whose requests will always return
+// a response configured by the handler, with faults injected according to the fault options.
+func testBankIDWithConfiguration(
+	clientConfiguration *configuration.Configuration,
+	handler http.HandlerFunc,
+	faults ...fault.Option,
+) (*BankIDClient, func()) {
 	bankID, _ := NewBankIDClient(clientConfiguration)
 
 	httpClient, teardown := testHTTPClient(handler)
 
+	if len(faults) > 0 {
+		httpClient.Transport = fault.NewTransport(httpClient.Transport, faults...)
+	}
+
 	client, _ := bankIdHttp.NewClient(clientConfiguration, bankIdHttp.WithHTTPClient(httpClient))
 	bankID.client = client
 
@@ -266,6 +616,21 @@ func stringToResponseHandler(t *testing.T, body string) http.HandlerFunc {
 	}
 }
 
+// invalidParametersHandler returns the response of the BankID RP API when collecting a non-existing order.
+func invalidParametersHandler(t *testing.T) http.HandlerFunc {
+	t.Helper()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		// nolint:errcheck
+		// #nosec G104
+		io.WriteString(w, `{"errorCode":"invalidParameters","details":"No such order"}`)
+	}
+}
+
 func loadFile(path string) []byte {
 	// #nosec G304
 	fileContent, err := os.ReadFile(path)
@@ -294,3 +659,28 @@ func getResourceDirectoryPath() (directory string, err error) {
 
 	return fmt.Sprintf("%s/%s", path.Dir(filename), "./resource"), nil
 }
+
+func TestIsTemporary(t *testing.T) {
+	assert.True(t, IsTemporary(&APIError{ErrorCode: string(response.ErrorMaintenance)}))
+	assert.True(t, IsTemporary(fmt.Errorf("wrapped. %w", &APIError{ErrorCode: string(response.ErrorInternalError)})))
+	assert.True(t, IsTemporary(&net.OpError{Op: "dial", Err: errors.New("connection refused")}))
+	assert.False(t, IsTemporary(&APIError{ErrorCode: string(response.ErrorInvalidParameters)}))
+	assert.False(t, IsTemporary(NewValidationError("OrderRef", "", errors.New("required"))))
+	assert.True(t, IsTemporary(fmt.Errorf("unable to decode response %w", io.ErrUnexpectedEOF)))
+	assert.True(t, IsTemporary(context.DeadlineExceeded))
+	assert.False(t, IsTemporary(&json.SyntaxError{}))
+	assert.False(t, IsTemporary(context.Canceled))
+	assert.False(t, IsTemporary(nil))
+}
+
+func TestIsTemporaryRefusedCertificate(t *testing.T) {
+	bankID, teardown := testBankID(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusForbidden)
+		fileToResponseHandler(t, "resource/test_data/403_forbidden_response.html")(writer, request)
+	})
+	defer teardown()
+
+	_, err := bankID.Collect(context.Background(), &payload.CollectPayload{OrderRef: probeOrderRef})
+	assert.ErrorContains(t, err, "403 Forbidden")
+	assert.False(t, IsTemporary(err))
+}
@@ -0,0 +1,169 @@
+package collect
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/e-identification/bankid-go/pkg"
+	"github.com/e-identification/bankid-go/pkg/payload"
+	"github.com/e-identification/bankid-go/pkg/response"
+)
+
+// The defaults of the Deduplicator.
+const (
+	defaultTTL         = time.Second
+	defaultCallTimeout = 10 * time.Second
+)
+
+// To ensure that Deduplicator implements the Collector interface.
+var _ pkg.Collector = (*Deduplicator)(nil)
+
+// call holds a collect of an order, shared by all concurrent callers.
+type call struct {
+	done        chan struct{}
+	response    *response.CollectResponse
+	err         error
+	collectedAt time.Time
+}
+
+// terminal returns true if the order is complete or failed.
+func (c *call) terminal() bool {
+	return c.err == nil && c.response != nil && !c.response.IsPending()
+}
+
+// Deduplicator is a pkg.Collector that coalesces concurrent collects of the same order into a single call of the
+// underlying collector.
+//
+// The latest response of a pending order is cached for the TTL, and the response of a complete or failed order is
+// cached until the order is evicted, which keeps the load on the BankID RP API proportional to the number of orders
+// rather than the number of callers. Errors are not cached. The responses are shared between the callers and must not
+// be modified.
+type Deduplicator struct {
+	collector pkg.Collector
+	ttl       time.Duration
+	timeout   time.Duration
+
+	mutex sync.Mutex
+	calls map[string]*call
+}
+
+// DeduplicatorOption definition.
+type DeduplicatorOption func(*Deduplicator)
+
+// NewDeduplicator returns a new instance of 'Deduplicator' in front of the collector.
+func NewDeduplicator(collector pkg.Collector, options ...DeduplicatorOption) *Deduplicator {
+	instance := &Deduplicator{
+		collector: collector, ttl: defaultTTL, timeout: defaultCallTimeout, calls: map[string]*call{},
+	}
+
+	// Apply options if there are any, can overwrite default
+	for _, option := range options {
+		option(instance)
+	}
+
+	return instance
+}
+
+// WithTTL Function to create DeduplicatorOption func to set the duration the response of a pending order is cached,
+// defaults to one second.
+func WithTTL(ttl time.Duration) DeduplicatorOption {
+	return func(subject *Deduplicator) {
+		subject.ttl = ttl
+	}
+}
+
+// WithCallTimeout Function to create DeduplicatorOption func to set the timeout of the shared call, defaults to 10
+// seconds. The shared call is not cancelled when a single caller gives up.
+func WithCallTimeout(timeout time.Duration) DeduplicatorOption {
+	return func(subject *Deduplicator) {
+		subject.timeout = timeout
+	}
+}
+
+// Collect - Collects the result of a sign or auth order, sharing the call with concurrent callers of the same order.
+//
+// See BankIDClient.Collect.
+func (d *Deduplicator) Collect(
+	context context.Context,
+	payload *payload.CollectPayload,
+) (*response.CollectResponse, error) {
+	d.mutex.Lock()
+
+	current, ok := d.calls[payload.OrderRef]
+	if !ok || d.expired(current) {
+		current = &call{done: make(chan struct{})}
+		d.calls[payload.OrderRef] = current
+
+		go d.collect(context, payload, current)
+	}
+
+	d.mutex.Unlock()
+
+	select {
+	case <-current.done:
+		return current.response, current.err
+	case <-context.Done():
+		return nil, context.Err() // nolint:wrapcheck
+	}
+}
+
+// Evict removes the cached response of the order.
+func (d *Deduplicator) Evict(orderRef string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	delete(d.calls, orderRef)
+}
+
+// Len returns the number of orders with a cached response or a call in flight.
+func (d *Deduplicator) Len() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return len(d.calls)
+}
+
+// expired returns true if a new call is needed, the call is expected to be guarded by the mutex.
+func (d *Deduplicator) expired(current *call) bool {
+	select {
+	case <-current.done:
+		return !current.terminal() && time.Since(current.collectedAt) >= d.ttl
+	default:
+		return false
+	}
+}
+
+// collect makes the shared call. The call is detached from the cancellation of the caller that started it, as other
+// callers may be waiting for it.
+func (d *Deduplicator) collect(ctx context.Context, payload *payload.CollectPayload, current *call) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), d.timeout)
+	defer cancel()
+
+	collected, err := d.collector.Collect(ctx, payload)
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	current.response, current.err, current.collectedAt = collected, err, time.Now()
+	close(current.done)
+
+	if d.calls[payload.OrderRef] != current {
+		return
+	}
+
+	switch {
+	case err != nil:
+		delete(d.calls, payload.OrderRef)
+	case !current.terminal():
+		// Forget the pending response once it has expired, terminal responses are kept until evicted
+		time.AfterFunc(d.ttl, func() {
+			d.mutex.Lock()
+			defer d.mutex.Unlock()
+
+			if d.calls[payload.OrderRef] == current {
+				delete(d.calls, payload.OrderRef)
+			}
+		})
+	}
+}
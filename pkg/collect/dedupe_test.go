@@ -0,0 +1,135 @@
+package collect
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/e-identification/bankid-go/pkg/payload"
+	"github.com/e-identification/bankid-go/pkg/response"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeduplicatorCoalescesConcurrentCalls(t *testing.T) {
+	var calls atomic.Int32
+
+	release := make(chan struct{})
+	deduplicator := NewDeduplicator(collectorFunc(func(context.Context, *payload.CollectPayload,
+	) (*response.CollectResponse, error) {
+		calls.Add(1)
+		<-release
+
+		return &response.CollectResponse{Status: response.StatusPending}, nil
+	}))
+
+	var waitGroup sync.WaitGroup
+
+	for range 10 {
+		waitGroup.Add(1)
+
+		go func() {
+			defer waitGroup.Done()
+
+			collected, err := deduplicator.Collect(context.Background(), &payload.CollectPayload{OrderRef: "order"})
+			assert.NoError(t, err)
+			assert.Equal(t, response.StatusPending, collected.Status)
+		}()
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	waitGroup.Wait()
+
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestDeduplicatorCachesPendingResponseForTTL(t *testing.T) {
+	var calls atomic.Int32
+
+	deduplicator := NewDeduplicator(collectorFunc(func(context.Context, *payload.CollectPayload,
+	) (*response.CollectResponse, error) {
+		calls.Add(1)
+
+		return &response.CollectResponse{Status: response.StatusPending}, nil
+	}), WithTTL(20*time.Millisecond))
+
+	collectOrder(t, deduplicator)
+	collectOrder(t, deduplicator)
+	assert.Equal(t, int32(1), calls.Load())
+
+	time.Sleep(30 * time.Millisecond)
+
+	collectOrder(t, deduplicator)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestDeduplicatorCachesTerminalResponseUntilEvicted(t *testing.T) {
+	var calls atomic.Int32
+
+	deduplicator := NewDeduplicator(collectorFunc(func(context.Context, *payload.CollectPayload,
+	) (*response.CollectResponse, error) {
+		calls.Add(1)
+
+		return &response.CollectResponse{Status: response.StatusComplete}, nil
+	}), WithTTL(time.Millisecond))
+
+	collectOrder(t, deduplicator)
+	time.Sleep(5 * time.Millisecond)
+	collectOrder(t, deduplicator)
+	assert.Equal(t, int32(1), calls.Load())
+	assert.Equal(t, 1, deduplicator.Len())
+
+	deduplicator.Evict("order")
+	collectOrder(t, deduplicator)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestDeduplicatorDoesNotCacheErrors(t *testing.T) {
+	var calls atomic.Int32
+
+	deduplicator := NewDeduplicator(collectorFunc(func(context.Context, *payload.CollectPayload,
+	) (*response.CollectResponse, error) {
+		calls.Add(1)
+
+		return nil, errors.New("connection reset")
+	}))
+
+	for range 2 {
+		_, err := deduplicator.Collect(context.Background(), &payload.CollectPayload{OrderRef: "order"})
+		assert.ErrorContains(t, err, "connection reset")
+	}
+
+	assert.Equal(t, int32(2), calls.Load())
+	assert.Equal(t, 0, deduplicator.Len())
+}
+
+func TestDeduplicatorSharedCallOutlivesCancelledCaller(t *testing.T) {
+	release := make(chan struct{})
+	deduplicator := NewDeduplicator(collectorFunc(func(ctx context.Context, _ *payload.CollectPayload,
+	) (*response.CollectResponse, error) {
+		<-release
+
+		return &response.CollectResponse{Status: response.StatusPending}, ctx.Err()
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := deduplicator.Collect(ctx, &payload.CollectPayload{OrderRef: "order"})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	close(release)
+	collectOrder(t, deduplicator)
+}
+
+func collectOrder(t *testing.T, deduplicator *Deduplicator) {
+	t.Helper()
+
+	if _, err := deduplicator.Collect(context.Background(), &payload.CollectPayload{OrderRef: "order"}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,248 @@
+package collect
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// staleMutexAge is the age of a mutex file of the FileLeaser that is considered left behind by a crashed process.
+const staleMutexAge = 10 * time.Second
+
+// Leaser grants leases keyed by orderRef, which makes sure that a single holder, such as a replica of a service,
+// collects each order at a time.
+type Leaser interface {
+	// Acquire acquires the lease of the order for the holder, or renews it if the holder already holds it, until the
+	// TTL has passed. It returns false if another holder holds a lease that has not expired.
+	Acquire(ctx context.Context, orderRef string, holder string, ttl time.Duration) (bool, error)
+	// Release releases the lease of the order if the holder holds it.
+	Release(ctx context.Context, orderRef string, holder string) error
+}
+
+// To ensure that the leasers implement the Leaser interface.
+var (
+	_ Leaser = (*MemoryLeaser)(nil)
+	_ Leaser = (*FileLeaser)(nil)
+)
+
+// lease holds the holder of a lease and the time it expires.
+type lease struct {
+	Holder    string    `json:"holder"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// MemoryLeaser is a Leaser holding the leases in memory, suitable for a single process and for tests.
+type MemoryLeaser struct {
+	mutex  sync.Mutex
+	leases map[string]lease
+}
+
+// NewMemoryLeaser returns a new instance of 'MemoryLeaser'.
+func NewMemoryLeaser() *MemoryLeaser {
+	return &MemoryLeaser{leases: map[string]lease{}}
+}
+
+// Acquire acquires or renews the lease of the order for the holder.
+func (m *MemoryLeaser) Acquire(_ context.Context, orderRef string, holder string, ttl time.Duration) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+
+	if current, ok := m.leases[orderRef]; ok && current.Holder != holder && now.Before(current.ExpiresAt) {
+		return false, nil
+	}
+
+	m.leases[orderRef] = lease{Holder: holder, ExpiresAt: now.Add(ttl)}
+
+	return true, nil
+}
+
+// Release releases the lease of the order if the holder holds it.
+func (m *MemoryLeaser) Release(_ context.Context, orderRef string, holder string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if current, ok := m.leases[orderRef]; ok && current.Holder == holder {
+		delete(m.leases, orderRef)
+	}
+
+	return nil
+}
+
+// FileLeaser is a Leaser holding each lease in a file of a directory, which may be shared between processes.
+//
+// A lease is read and written under a mutex file that is created exclusively, a mutex file left behind by a crashed
+// process is taken over after ten seconds.
+type FileLeaser struct {
+	directory string
+}
+
+// NewFileLeaser returns a new instance of 'FileLeaser' holding the leases in the directory, which is created if it
+// does not exist.
+func NewFileLeaser(directory string) (*FileLeaser, error) {
+	if err := os.MkdirAll(directory, 0o700); err != nil {
+		return nil, fmt.Errorf("unable to create the lease directory. %w", err)
+	}
+
+	return &FileLeaser{directory: directory}, nil
+}
+
+// Acquire acquires or renews the lease of the order for the holder.
+func (f *FileLeaser) Acquire(ctx context.Context, orderRef string, holder string, ttl time.Duration) (bool, error) {
+	acquired := false
+
+	err := f.locked(ctx, orderRef, func(path string) error {
+		current, err := readLease(path)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if current != nil && current.Holder != holder && now.Before(current.ExpiresAt) {
+			return nil
+		}
+
+		content, err := json.Marshal(lease{Holder: holder, ExpiresAt: now.Add(ttl)})
+		if err != nil {
+			return fmt.Errorf("unable to encode the lease. %w", err)
+		}
+
+		// The lease is replaced atomically, a crash never leaves a partial lease behind
+		if err := os.WriteFile(path+".tmp", content, 0o600); err != nil {
+			return fmt.Errorf("unable to write the lease. %w", err)
+		}
+
+		if err := os.Rename(path+".tmp", path); err != nil {
+			return fmt.Errorf("unable to write the lease. %w", err)
+		}
+
+		acquired = true
+
+		return nil
+	})
+
+	return acquired, err
+}
+
+// Release releases the lease of the order if the holder holds it.
+func (f *FileLeaser) Release(ctx context.Context, orderRef string, holder string) error {
+	return f.locked(ctx, orderRef, func(path string) error {
+		current, err := readLease(path)
+		if err != nil || current == nil || current.Holder != holder {
+			return err
+		}
+
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("unable to remove the lease. %w", err)
+		}
+
+		return nil
+	})
+}
+
+// locked invokes the function with the path of the lease file while holding the mutex file of the order.
+func (f *FileLeaser) locked(ctx context.Context, orderRef string, function func(path string) error) error {
+	// The orderRef is encoded, as it is not trusted to be a file name
+	path := filepath.Join(f.directory, base64.RawURLEncoding.EncodeToString([]byte(orderRef))+".lease")
+	mutex := path + ".mutex"
+
+	for {
+		file, err := os.OpenFile(mutex, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600) // #nosec G304
+		if err == nil {
+			_ = file.Close()
+
+			break
+		}
+
+		if !errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("unable to lock the lease. %w", err)
+		}
+
+		if err := takeOver(mutex); err != nil {
+			return fmt.Errorf("unable to take over the lease lock. %w", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err() // nolint:wrapcheck
+		case <-time.After(time.Millisecond):
+		}
+	}
+
+	defer os.Remove(mutex) // nolint:errcheck
+
+	return function(path)
+}
+
+// takeOver removes the mutex file if it is stale. The removal is made while holding a takeover file that is created
+// exclusively, and the mutex file is checked again under it, so that a mutex file created after a stale one was
+// removed is never removed in its place.
+func takeOver(mutex string) error {
+	if !isStale(mutex) {
+		return nil
+	}
+
+	takeover := mutex + ".takeover"
+
+	file, err := os.OpenFile(takeover, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600) // #nosec G304
+	if errors.Is(err, fs.ErrExist) {
+		// Another process is taking over the mutex file, unless it crashed and left the takeover file behind
+		if isStale(takeover) {
+			_ = os.Remove(takeover)
+		}
+
+		return nil
+	}
+
+	if err != nil {
+		return err // nolint:wrapcheck
+	}
+
+	_ = file.Close()
+
+	defer os.Remove(takeover) // nolint:errcheck
+
+	if !isStale(mutex) {
+		return nil
+	}
+
+	if err := os.Remove(mutex); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err // nolint:wrapcheck
+	}
+
+	return nil
+}
+
+// isStale returns true if the file exists and is older than staleMutexAge.
+func isStale(path string) bool {
+	info, err := os.Stat(path)
+
+	return err == nil && time.Since(info.ModTime()) > staleMutexAge
+}
+
+// readLease reads the lease file, it returns nil if there is no lease.
+func readLease(path string) (*lease, error) {
+	content, err := os.ReadFile(path) // #nosec G304
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil // nolint:nilnil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the lease. %w", err)
+	}
+
+	current := &lease{}
+	if err := json.Unmarshal(content, current); err != nil {
+		return nil, fmt.Errorf("unable to decode the lease. %w", err)
+	}
+
+	return current, nil
+}
@@ -0,0 +1,195 @@
+package collect
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/e-identification/bankid-go/pkg/payload"
+	"github.com/e-identification/bankid-go/pkg/response"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeasers(t *testing.T) {
+	for name, newLeaser := range map[string]func(t *testing.T) Leaser{
+		"memory": func(*testing.T) Leaser { return NewMemoryLeaser() },
+		"file": func(t *testing.T) Leaser {
+			t.Helper()
+
+			leaser, err := NewFileLeaser(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			return leaser
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			testLeaser(t, newLeaser(t))
+		})
+	}
+}
+
+func testLeaser(t *testing.T, leaser Leaser) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	acquire := func(holder string, ttl time.Duration) bool {
+		acquired, err := leaser.Acquire(ctx, "../order", holder, ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return acquired
+	}
+
+	assert.True(t, acquire("first", 20*time.Millisecond))
+	assert.False(t, acquire("second", time.Minute))
+	assert.True(t, acquire("first", 20*time.Millisecond), "renewal")
+
+	time.Sleep(30 * time.Millisecond)
+	assert.True(t, acquire("second", time.Minute), "takeover of an expired lease")
+	assert.False(t, acquire("first", time.Minute))
+
+	assert.NoError(t, leaser.Release(ctx, "../order", "first"))
+	assert.False(t, acquire("first", time.Minute), "release by another holder")
+
+	assert.NoError(t, leaser.Release(ctx, "../order", "second"))
+	assert.True(t, acquire("first", time.Minute))
+}
+
+func TestFileLeaserGrantsSingleHolder(t *testing.T) {
+	leaser, err := NewFileLeaser(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		acquired  atomic.Int32
+		waitGroup sync.WaitGroup
+	)
+
+	for i := range 20 {
+		waitGroup.Add(1)
+
+		go func() {
+			defer waitGroup.Done()
+
+			ok, err := leaser.Acquire(context.Background(), "order", fmt.Sprintf("holder-%d", i), time.Minute)
+			assert.NoError(t, err)
+
+			if ok {
+				acquired.Add(1)
+			}
+		}()
+	}
+
+	waitGroup.Wait()
+
+	assert.Equal(t, int32(1), acquired.Load())
+}
+
+func TestFileLeaserTakesOverStaleMutex(t *testing.T) {
+	directory := t.TempDir()
+
+	leaser, err := NewFileLeaser(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mutex := filepath.Join(directory, base64.RawURLEncoding.EncodeToString([]byte("order"))+".lease.mutex")
+	stale := time.Now().Add(-2 * staleMutexAge)
+
+	// A mutex file and a takeover file left behind by crashed processes
+	for _, path := range []string{mutex, mutex + ".takeover"} {
+		if err := os.WriteFile(path, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.Chtimes(path, stale, stale); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	acquired, err := leaser.Acquire(ctx, "order", "holder", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+
+	assert.NoFileExists(t, mutex)
+	assert.NoFileExists(t, mutex+".takeover")
+}
+
+func TestTakeOverKeepsFreshMutex(t *testing.T) {
+	mutex := filepath.Join(t.TempDir(), "order.lease.mutex")
+
+	if err := os.WriteFile(mutex, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NoError(t, takeOver(mutex))
+	assert.FileExists(t, mutex)
+}
+
+func TestSchedulerLeaseOutlivesCollect(t *testing.T) {
+	assert.Equal(t, defaultTimeout+defaultInterval, NewScheduler(nil).leaseTTL)
+	assert.Equal(t, defaultTimeout+defaultInterval, NewScheduler(nil, WithLeaseTTL(time.Second)).leaseTTL)
+	assert.Equal(t, time.Minute, NewScheduler(nil, WithLeaseTTL(time.Minute)).leaseTTL)
+}
+
+func TestSchedulersShareOrdersByLease(t *testing.T) {
+	var (
+		leaser   = NewMemoryLeaser()
+		holders  sync.Map
+		calls    atomic.Int32
+		complete = &response.CollectResponse{Status: response.StatusComplete}
+	)
+
+	newScheduler := func(holder string) *Scheduler {
+		scheduler := NewScheduler(collectorFunc(func(context.Context, *payload.CollectPayload,
+		) (*response.CollectResponse, error) {
+			// The order is complete for every instance once it has been collected three times
+			if calls.Load() >= 3 {
+				return complete, nil
+			}
+
+			holders.Store(holder, true)
+
+			if calls.Add(1) == 3 {
+				return complete, nil
+			}
+
+			return &response.CollectResponse{Status: response.StatusPending}, nil
+		}), WithLeaser(leaser, holder), WithInterval(10*time.Millisecond), WithTick(time.Millisecond))
+
+		scheduler.Subscribe("order", func(Result) {})
+
+		return scheduler
+	}
+
+	first, second := newScheduler("first"), newScheduler("second")
+	runScheduler(t, first)
+	runScheduler(t, second)
+
+	assert.Eventually(t, func() bool { return first.Len()+second.Len() == 0 }, time.Second, time.Millisecond)
+
+	count := 0
+
+	holders.Range(func(any, any) bool {
+		count++
+
+		return true
+	})
+
+	assert.Equal(t, 1, count)
+}
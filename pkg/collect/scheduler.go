@@ -0,0 +1,492 @@
+// Package collect provides utilities for collecting many pending orders against the BankID RP API.
+package collect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/e-identification/bankid-go/pkg"
+	"github.com/e-identification/bankid-go/pkg/payload"
+	"github.com/e-identification/bankid-go/pkg/response"
+)
+
+// The defaults of the Scheduler.
+const (
+	defaultInterval = 2 * time.Second
+	defaultTick     = 100 * time.Millisecond
+	defaultWorkers  = 16
+	defaultTimeout  = 10 * time.Second
+	// The default TTL of a lease in intervals, the lease is renewed on every collect. The TTL is raised to outlive the
+	// slowest collect, see WithLeaseTTL.
+	defaultLeaseIntervals = 3
+)
+
+// ErrOrderExpired is the error of the result of an order that is still pending once its lifetime has passed since it
+// was scheduled, see WithLifetime.
+var ErrOrderExpired = errors.New("order has outlived its lifetime")
+
+// Result holds the outcome of a collect of an order.
+type Result struct {
+	// The reference of the collected order.
+	OrderRef string
+	// The response of the collect, nil if the collect failed.
+	Response *response.CollectResponse
+	// The error of the collect, nil if the collect succeeded.
+	Err error
+	// Done is true when the order has been removed from the scheduler, as the order is complete or failed, the error
+	// is not temporary or the lifetime of the order has passed. No more results are dispatched for the order.
+	Done bool
+}
+
+// Handler is invoked with each result of an order. It is invoked from the worker that collected the order and should
+// return quickly.
+type Handler func(result Result)
+
+// subscriber holds a Handler and the ID used to unsubscribe it.
+type subscriber struct {
+	id      uint64
+	handler Handler
+}
+
+// order holds a pending order and its position in the timing wheel.
+type order struct {
+	orderRef    string
+	scheduled   time.Time
+	slot        int
+	subscribers []subscriber
+	removed     bool
+}
+
+// Scheduler collects pending orders and dispatches the results to the subscribers of each order.
+//
+// All orders are managed by a single timing wheel, which turns once per interval and holds each order in one of its
+// slots. New orders are spread evenly over the slots, which avoids bursts of collects when many orders are started at
+// the same time, and each order is collected once per interval by a bounded pool of workers. An order is removed when
+// it is complete or failed, when the collect fails with an error that is not temporary, see pkg.IsTemporary, when its
+// lifetime has passed since it was scheduled, see WithLifetime, or when its last subscriber unsubscribes.
+//
+// With an order store, see WithOrderStore, the status of each collected order is written to the store and the pending
+// orders of the store can be scheduled by SyncStore, which lets any instance of a service collect the orders. With a
+// leaser, see WithLeaser, an order is only collected by the instance holding its lease, and the other instances leave
+// it to that instance until the lease expires.
+type Scheduler struct {
+	collector pkg.Collector
+	store     pkg.OrderStore
+	leaser    Leaser
+	holder    string
+	leaseTTL  time.Duration
+	interval  time.Duration
+	tick      time.Duration
+	workers   int
+	timeout   time.Duration
+	lifetime  time.Duration
+
+	mutex  sync.Mutex
+	orders map[string]*order
+	wheel  [][]*order
+	cursor int
+	placed int
+	nextID uint64
+}
+
+// Option definition.
+type Option func(*Scheduler)
+
+// NewScheduler returns a new instance of 'Scheduler' collecting the orders using the collector.
+func NewScheduler(collector pkg.Collector, options ...Option) *Scheduler {
+	instance := &Scheduler{
+		collector: collector, interval: defaultInterval, tick: defaultTick, workers: defaultWorkers,
+		timeout: defaultTimeout, lifetime: pkg.OrderLifetime, orders: map[string]*order{},
+	}
+
+	// Apply options if there are any, can overwrite default
+	for _, option := range options {
+		option(instance)
+	}
+
+	if instance.leaseTTL == 0 {
+		instance.leaseTTL = defaultLeaseIntervals * instance.interval
+	}
+
+	// The lease is renewed when the order is collected, it must not expire before the collect has timed out and the
+	// order is due again
+	instance.leaseTTL = max(instance.leaseTTL, instance.timeout+instance.interval)
+
+	slots := max(int(instance.interval/instance.tick), 1)
+	instance.wheel = make([][]*order, slots)
+
+	return instance
+}
+
+// WithInterval Function to create Option func to set the interval between two collects of an order, defaults to the
+// two seconds recommended by BankID.
+func WithInterval(interval time.Duration) Option {
+	return func(subject *Scheduler) {
+		subject.interval = interval
+	}
+}
+
+// WithTick Function to create Option func to set the duration of a slot of the timing wheel, which decides how evenly
+// the collects are spread over the interval. Defaults to 100 milliseconds.
+func WithTick(tick time.Duration) Option {
+	return func(subject *Scheduler) {
+		subject.tick = tick
+	}
+}
+
+// WithWorkers Function to create Option func to set the number of concurrent collects, defaults to 16.
+func WithWorkers(workers int) Option {
+	return func(subject *Scheduler) {
+		subject.workers = workers
+	}
+}
+
+// WithTimeout Function to create Option func to set the timeout of a single collect, defaults to 10 seconds.
+func WithTimeout(timeout time.Duration) Option {
+	return func(subject *Scheduler) {
+		subject.timeout = timeout
+	}
+}
+
+// WithLifetime Function to create Option func to set the time after which an order that is still pending is removed,
+// counted from when the order was scheduled. Defaults to pkg.OrderLifetime, after which the BankID RP API has expired
+// the order.
+func WithLifetime(lifetime time.Duration) Option {
+	return func(subject *Scheduler) {
+		subject.lifetime = lifetime
+	}
+}
+
+// WithOrderStore Function to create Option func to write the status of the collected orders to the store. An order
+// that is deleted from the store is removed from the scheduler.
+func WithOrderStore(store pkg.OrderStore) Option {
+	return func(subject *Scheduler) {
+		subject.store = store
+	}
+}
+
+// WithLeaser Function to create Option func to only collect the orders whose lease is acquired from the leaser by
+// the holder, which identifies the instance of the service. Subscribers are only notified by the instance that holds
+// the lease, use an order store to share the results with the other instances.
+func WithLeaser(leaser Leaser, holder string) Option {
+	return func(subject *Scheduler) {
+		subject.leaser = leaser
+		subject.holder = holder
+	}
+}
+
+// WithLeaseTTL Function to create Option func to set the TTL of the leases, defaults to three intervals. The TTL
+// decides how long the other instances wait before taking over the orders of an instance that has stopped. A TTL
+// shorter than the timeout of a collect plus the interval is raised to it, so that a lease never expires while the
+// order is being collected.
+func WithLeaseTTL(ttl time.Duration) Option {
+	return func(subject *Scheduler) {
+		subject.leaseTTL = ttl
+	}
+}
+
+// Subscribe adds the handler to the subscribers of the order and schedules the order if it is not already pending.
+//
+// The returned function unsubscribes the handler, the order is removed when it has no subscribers left.
+func (s *Scheduler) Subscribe(orderRef string, handler Handler) func() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	pending, ok := s.orders[orderRef]
+	if !ok {
+		pending = &order{orderRef: orderRef, scheduled: time.Now()}
+		s.orders[orderRef] = pending
+		s.place(pending)
+	}
+
+	s.nextID++
+	id := s.nextID
+	pending.subscribers = append(pending.subscribers, subscriber{id: id, handler: handler})
+
+	return func() {
+		s.unsubscribe(pending, id)
+	}
+}
+
+// SyncStore schedules the pending orders of the order store that are not already pending in the scheduler and returns
+// the number of orders it scheduled. The orders are collected until they are complete or failed.
+func (s *Scheduler) SyncStore(ctx context.Context) (int, error) {
+	if s.store == nil {
+		return 0, errors.New("no order store")
+	}
+
+	orders, err := s.store.ListPending(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("unable to list the pending orders. %w", err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	scheduled := 0
+
+	for _, stored := range orders {
+		if _, ok := s.orders[stored.OrderRef]; ok {
+			continue
+		}
+
+		pending := &order{
+			orderRef: stored.OrderRef, scheduled: time.Now(), subscribers: []subscriber{{handler: func(Result) {}}},
+		}
+		s.orders[stored.OrderRef] = pending
+		s.place(pending)
+
+		scheduled++
+	}
+
+	return scheduled, nil
+}
+
+// Remove removes the order without notifying its subscribers.
+func (s *Scheduler) Remove(orderRef string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if pending, ok := s.orders[orderRef]; ok {
+		s.remove(pending)
+	}
+}
+
+// Len returns the number of pending orders.
+func (s *Scheduler) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.orders)
+}
+
+// Run turns the timing wheel and collects the due orders until the context is done.
+//
+// Run blocks until the context is done and the collects in flight have finished.
+func (s *Scheduler) Run(ctx context.Context) {
+	jobs := make(chan *order, s.workers)
+
+	var waitGroup sync.WaitGroup
+
+	for range s.workers {
+		waitGroup.Add(1)
+
+		go func() {
+			defer waitGroup.Done()
+
+			for pending := range jobs {
+				s.collect(ctx, pending)
+			}
+		}()
+	}
+
+	defer waitGroup.Wait()
+	defer close(jobs)
+
+	ticker := time.NewTicker(s.tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, pending := range s.advance() {
+				// Block when all workers are busy, which delays the wheel rather than piling up collects
+				select {
+				case jobs <- pending:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}
+}
+
+// advance moves the cursor to the next slot and returns the orders due in it.
+func (s *Scheduler) advance() []*order {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.cursor = (s.cursor + 1) % len(s.wheel)
+
+	due := s.wheel[s.cursor]
+	s.wheel[s.cursor] = nil
+
+	active := due[:0]
+
+	for _, pending := range due {
+		if !pending.removed {
+			active = append(active, pending)
+		}
+	}
+
+	return active
+}
+
+// place puts a new order in the slots following the cursor in turn, spreading the orders over the interval.
+func (s *Scheduler) place(pending *order) {
+	s.placed++
+	pending.slot = (s.cursor + s.placed) % len(s.wheel)
+	s.wheel[pending.slot] = append(s.wheel[pending.slot], pending)
+}
+
+// collect collects the order, dispatches the result and puts the order back in its slot unless it is done.
+func (s *Scheduler) collect(ctx context.Context, pending *order) {
+	collectCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	if time.Since(pending.scheduled) >= s.lifetime {
+		s.expire(collectCtx, pending)
+
+		return
+	}
+
+	held, err := s.acquire(collectCtx, pending.orderRef)
+
+	var result Result
+
+	switch {
+	case err != nil:
+		result = Result{OrderRef: pending.orderRef, Err: fmt.Errorf("unable to acquire the lease. %w", err)}
+	case !held:
+		// Another holder collects the order, which is checked again on the next turn of the wheel
+		s.requeue(pending)
+
+		return
+	default:
+		result = s.collectOrder(collectCtx, pending.orderRef)
+	}
+
+	s.mutex.Lock()
+
+	if pending.removed {
+		s.mutex.Unlock()
+
+		return
+	}
+
+	// The result is dropped and the order left pending when the scheduler is stopped during the collect
+	stopped := ctx.Err() != nil
+
+	if result.Done && !stopped {
+		s.remove(pending)
+	} else {
+		s.wheel[pending.slot] = append(s.wheel[pending.slot], pending)
+	}
+
+	subscribers := pending.subscribers
+
+	s.mutex.Unlock()
+
+	if stopped {
+		return
+	}
+
+	if result.Done && s.leaser != nil {
+		// A lease that could not be released expires
+		_ = s.leaser.Release(context.WithoutCancel(collectCtx), pending.orderRef, s.holder)
+	}
+
+	for _, subscriber := range subscribers {
+		subscriber.handler(result)
+	}
+}
+
+// expire removes the order, whose lifetime has passed, releases its lease and notifies its subscribers.
+func (s *Scheduler) expire(ctx context.Context, pending *order) {
+	s.mutex.Lock()
+
+	if pending.removed {
+		s.mutex.Unlock()
+
+		return
+	}
+
+	s.remove(pending)
+	subscribers := pending.subscribers
+
+	s.mutex.Unlock()
+
+	if s.leaser != nil {
+		// A lease that could not be released expires
+		_ = s.leaser.Release(context.WithoutCancel(ctx), pending.orderRef, s.holder)
+	}
+
+	result := Result{OrderRef: pending.orderRef, Err: ErrOrderExpired, Done: true}
+
+	for _, subscriber := range subscribers {
+		subscriber.handler(result)
+	}
+}
+
+// collectOrder collects the order and writes its status to the order store.
+func (s *Scheduler) collectOrder(ctx context.Context, orderRef string) Result {
+	collected, err := s.collector.Collect(ctx, &payload.CollectPayload{OrderRef: orderRef})
+
+	result := Result{OrderRef: orderRef, Response: collected, Err: err}
+
+	if err != nil {
+		result.Done = !pkg.IsTemporary(err) && ctx.Err() == nil
+
+		return result
+	}
+
+	result.Done = !collected.IsPending()
+
+	if s.store != nil {
+		// A failed update is retried on the next collect, unless the order was deleted from the store
+		updateErr := s.store.UpdateStatus(ctx, orderRef, collected.Status, collected.HintCode)
+		result.Done = result.Done || errors.Is(updateErr, pkg.ErrOrderNotFound)
+	}
+
+	return result
+}
+
+// acquire acquires or renews the lease of the order, it returns true if the scheduler has no leaser.
+func (s *Scheduler) acquire(ctx context.Context, orderRef string) (bool, error) {
+	if s.leaser == nil {
+		return true, nil
+	}
+
+	return s.leaser.Acquire(ctx, orderRef, s.holder, s.leaseTTL) // nolint:wrapcheck
+}
+
+// requeue puts the order back in its slot unless it has been removed.
+func (s *Scheduler) requeue(pending *order) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !pending.removed {
+		s.wheel[pending.slot] = append(s.wheel[pending.slot], pending)
+	}
+}
+
+func (s *Scheduler) unsubscribe(pending *order, id uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for i, subscriber := range pending.subscribers {
+		if subscriber.id == id {
+			pending.subscribers = append(pending.subscribers[:i:i], pending.subscribers[i+1:]...)
+
+			break
+		}
+	}
+
+	if len(pending.subscribers) == 0 && !pending.removed {
+		s.remove(pending)
+	}
+}
+
+// remove forgets the order, it is dropped from its slot when the slot is due.
+func (s *Scheduler) remove(pending *order) {
+	pending.removed = true
+
+	if s.orders[pending.orderRef] == pending {
+		delete(s.orders, pending.orderRef)
+	}
+}
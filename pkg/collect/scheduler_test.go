@@ -0,0 +1,278 @@
+package collect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/e-identification/bankid-go/pkg"
+	"github.com/e-identification/bankid-go/pkg/payload"
+	"github.com/e-identification/bankid-go/pkg/response"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// collectorFunc is a pkg.Collector backed by a function.
+type collectorFunc func(ctx context.Context, payload *payload.CollectPayload) (*response.CollectResponse, error)
+
+func (f collectorFunc) Collect(
+	ctx context.Context,
+	payload *payload.CollectPayload,
+) (*response.CollectResponse, error) {
+	return f(ctx, payload)
+}
+
+func TestSchedulerDispatchesUntilComplete(t *testing.T) {
+	var calls atomic.Int32
+
+	scheduler := NewScheduler(collectorFunc(func(_ context.Context, payload *payload.CollectPayload,
+	) (*response.CollectResponse, error) {
+		status := response.StatusPending
+		if calls.Add(1) == 3 {
+			status = response.StatusComplete
+		}
+
+		return &response.CollectResponse{OrderRef: payload.OrderRef, Status: status}, nil
+	}), WithInterval(20*time.Millisecond), WithTick(5*time.Millisecond))
+
+	results := make(chan Result, 10)
+	scheduler.Subscribe("order", func(result Result) { results <- result })
+
+	runScheduler(t, scheduler)
+
+	for _, status := range []response.Status{response.StatusPending, response.StatusPending, response.StatusComplete} {
+		result := <-results
+		assert.Equal(t, "order", result.OrderRef)
+		assert.Equal(t, status, result.Response.Status)
+		assert.Equal(t, status == response.StatusComplete, result.Done)
+	}
+
+	assert.Equal(t, 0, scheduler.Len())
+}
+
+func TestSchedulerRetriesTemporaryErrors(t *testing.T) {
+	var calls atomic.Int32
+
+	scheduler := NewScheduler(collectorFunc(func(context.Context, *payload.CollectPayload,
+	) (*response.CollectResponse, error) {
+		if calls.Add(1) == 1 {
+			return nil, &pkg.APIError{ErrorCode: string(response.ErrorMaintenance)}
+		}
+
+		return nil, &pkg.APIError{ErrorCode: string(response.ErrorInvalidParameters)}
+	}), WithInterval(20*time.Millisecond), WithTick(5*time.Millisecond))
+
+	results := make(chan Result, 10)
+	scheduler.Subscribe("order", func(result Result) { results <- result })
+
+	runScheduler(t, scheduler)
+
+	result := <-results
+	assert.ErrorContains(t, result.Err, "maintenance")
+	assert.False(t, result.Done)
+
+	result = <-results
+	assert.ErrorContains(t, result.Err, "invalidParameters")
+	assert.True(t, result.Done)
+	assert.Equal(t, 0, scheduler.Len())
+}
+
+func TestSchedulerRemovesExpiredOrder(t *testing.T) {
+	scheduler := NewScheduler(collectorFunc(func(context.Context, *payload.CollectPayload,
+	) (*response.CollectResponse, error) {
+		// A temporary error is retried until the order has expired
+		return nil, context.DeadlineExceeded
+	}), WithInterval(10*time.Millisecond), WithTick(time.Millisecond), WithLifetime(50*time.Millisecond))
+
+	results := make(chan Result, 100)
+	scheduler.Subscribe("order", func(result Result) { results <- result })
+
+	runScheduler(t, scheduler)
+
+	for result := range results {
+		if result.Done {
+			assert.ErrorIs(t, result.Err, ErrOrderExpired)
+
+			break
+		}
+
+		assert.ErrorIs(t, result.Err, context.DeadlineExceeded)
+	}
+
+	assert.Equal(t, 0, scheduler.Len())
+}
+
+func TestSchedulerRemovesOrderWithoutSubscribers(t *testing.T) {
+	scheduler := NewScheduler(collectorFunc(func(context.Context, *payload.CollectPayload,
+	) (*response.CollectResponse, error) {
+		return nil, errors.New("unexpected collect")
+	}))
+
+	first := scheduler.Subscribe("order", func(Result) {})
+	second := scheduler.Subscribe("order", func(Result) {})
+
+	first()
+	assert.Equal(t, 1, scheduler.Len())
+
+	second()
+	assert.Equal(t, 0, scheduler.Len())
+	assert.Empty(t, scheduler.advanceAll())
+}
+
+func TestSchedulerSpreadsOrdersOverTheWheel(t *testing.T) {
+	scheduler := NewScheduler(nil, WithInterval(time.Second), WithTick(100*time.Millisecond))
+
+	for i := range 100 {
+		scheduler.Subscribe(fmt.Sprintf("order-%d", i), func(Result) {})
+	}
+
+	for _, slot := range scheduler.wheel {
+		assert.Len(t, slot, 10)
+	}
+}
+
+func TestSchedulerBoundsConcurrentCollects(t *testing.T) {
+	var (
+		inFlight, peak atomic.Int32
+		collected      sync.WaitGroup
+	)
+
+	scheduler := NewScheduler(collectorFunc(func(context.Context, *payload.CollectPayload,
+	) (*response.CollectResponse, error) {
+		current := inFlight.Add(1)
+		defer inFlight.Add(-1)
+
+		for previous := peak.Load(); current > previous && !peak.CompareAndSwap(previous, current); {
+			previous = peak.Load()
+		}
+
+		time.Sleep(5 * time.Millisecond)
+
+		return &response.CollectResponse{Status: response.StatusComplete}, nil
+	}), WithInterval(10*time.Millisecond), WithTick(time.Millisecond), WithWorkers(4))
+
+	for i := range 50 {
+		collected.Add(1)
+		scheduler.Subscribe(fmt.Sprintf("order-%d", i), func(Result) { collected.Done() })
+	}
+
+	runScheduler(t, scheduler)
+	collected.Wait()
+
+	assert.LessOrEqual(t, peak.Load(), int32(4))
+}
+
+func TestSchedulerSyncsOrderStore(t *testing.T) {
+	store := pkg.NewMemoryOrderStore()
+	for _, orderRef := range []string{"first", "second"} {
+		_ = store.Create(context.Background(), pkg.NewPhoneAuthenticateOrder(
+			&response.PhoneAuthenticateResponse{OrderRef: orderRef}))
+	}
+
+	scheduler := NewScheduler(collectorFunc(func(_ context.Context, payload *payload.CollectPayload,
+	) (*response.CollectResponse, error) {
+		if payload.OrderRef == "second" {
+			_ = store.Delete(context.Background(), "second")
+		}
+
+		return &response.CollectResponse{Status: response.StatusComplete, HintCode: "done"}, nil
+	}), WithOrderStore(store), WithInterval(10*time.Millisecond), WithTick(time.Millisecond))
+
+	scheduled, err := scheduler.SyncStore(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 2, scheduled)
+
+	runScheduler(t, scheduler)
+
+	assert.Eventually(t, func() bool { return scheduler.Len() == 0 }, time.Second, time.Millisecond)
+
+	first, _ := store.Get(context.Background(), "first")
+	assert.Equal(t, response.StatusComplete, first.Status)
+	assert.Equal(t, "done", first.HintCode)
+
+	_, err = store.Get(context.Background(), "second")
+	assert.ErrorIs(t, err, pkg.ErrOrderNotFound)
+}
+
+func BenchmarkSchedulerPendingOrder(b *testing.B) {
+	scheduler := NewScheduler(nil)
+	handler := func(Result) {}
+	orderRefs := make([]string, b.N)
+
+	for i := range orderRefs {
+		orderRefs[i] = fmt.Sprintf("%08d-0000-0000-0000-000000000000", i)
+	}
+
+	var before, after runtime.MemStats
+
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	b.ResetTimer()
+
+	for _, orderRef := range orderRefs {
+		scheduler.Subscribe(orderRef, handler)
+	}
+
+	b.StopTimer()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+
+	b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc)/float64(b.N), "bytes/order")
+	runtime.KeepAlive(scheduler)
+}
+
+func BenchmarkSchedulerCollect(b *testing.B) {
+	pending := &response.CollectResponse{Status: response.StatusPending}
+	scheduler := NewScheduler(collectorFunc(func(context.Context, *payload.CollectPayload,
+	) (*response.CollectResponse, error) {
+		return pending, nil
+	}))
+
+	for i := range 10000 {
+		scheduler.Subscribe(fmt.Sprintf("order-%d", i), func(Result) {})
+	}
+
+	b.ResetTimer()
+
+	for range b.N {
+		for _, due := range scheduler.advance() {
+			scheduler.collect(context.Background(), due)
+		}
+	}
+}
+
+// advanceAll turns the wheel once and returns the orders that were due.
+func (s *Scheduler) advanceAll() []*order {
+	var due []*order
+
+	for range s.wheel {
+		due = append(due, s.advance()...)
+	}
+
+	return due
+}
+
+func runScheduler(t *testing.T, scheduler *Scheduler) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		scheduler.Run(ctx)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
@@ -0,0 +1,77 @@
+package configuration
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// KeyPair contains a parsed certificate chain, leaf first, and the private key of the leaf certificate.
+//
+// The private key only needs to implement crypto.Signer, which allows keys that never leave an HSM or a KMS to be
+// used for the mTLS handshake through PKCS#11 or KMS adapters.
+type KeyPair struct {
+	Certificates []*x509.Certificate
+	PrivateKey   crypto.Signer
+}
+
+// NewKeyPair creates a new key pair from a certificate chain, leaf first, and a signer holding the private key of
+// the leaf certificate.
+func NewKeyPair(certificates []*x509.Certificate, signer crypto.Signer) (*KeyPair, error) {
+	keyPair := &KeyPair{Certificates: certificates, PrivateKey: signer}
+	if _, err := keyPair.Certificate(); err != nil {
+		return nil, err
+	}
+
+	return keyPair, nil
+}
+
+// Certificate decodes the PKCS12 content into a tls.Certificate.
+func (p *Pkcs12) Certificate() (*tls.Certificate, error) {
+	key, leaf, caCertificates, err := pkcs12.DecodeChain(p.Content, p.Password)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load pkcs12. %w", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unable to load pkcs12. unsupported private key type %T", key)
+	}
+
+	return (&KeyPair{Certificates: append([]*x509.Certificate{leaf}, caCertificates...), PrivateKey: signer}).
+		Certificate()
+}
+
+// Certificate returns the key pair as a tls.Certificate.
+func (k *KeyPair) Certificate() (*tls.Certificate, error) {
+	if len(k.Certificates) == 0 {
+		return nil, errors.New("unable to load key pair. no certificate")
+	}
+
+	if k.PrivateKey == nil {
+		return nil, errors.New("unable to load key pair. no private key")
+	}
+
+	leaf := k.Certificates[0]
+
+	if !publicKeysMatch(leaf.PublicKey, k.PrivateKey.Public()) {
+		return nil, errors.New("unable to load key pair. private key does not match the certificate")
+	}
+
+	chain := make([][]byte, 0, len(k.Certificates))
+	for _, certificate := range k.Certificates {
+		chain = append(chain, certificate.Raw)
+	}
+
+	return &tls.Certificate{Certificate: chain, PrivateKey: k.PrivateKey, Leaf: leaf}, nil
+}
+
+func publicKeysMatch(certificateKey crypto.PublicKey, signerKey crypto.PublicKey) bool {
+	comparable, ok := certificateKey.(interface{ Equal(crypto.PublicKey) bool })
+
+	return ok && comparable.Equal(signerKey)
+}
@@ -1,12 +1,55 @@
 package configuration
 
+import "strings"
+
 // Environment contains the environment specific fields.
 type Environment struct {
+	// The URL the endpoint paths are appended to, such as https://appapi2.bankid.com/rp/v6.0.
 	BaseURL     string
 	Certificate string
+	// The URL of the BankID RP API without the versioned path, such as https://appapi2.bankid.com or the URL of a
+	// gateway including its path prefix.
+	RootURL string
+	// The version of the BankID RP API, the zero value if unknown.
+	APIVersion APIVersion
 }
 
 // NewEnvironment creates a new environment.
+//
+// The API version is derived from the versioned path at the end of the base URL, such as /rp/v6.0, and is unknown
+// if there is none.
 func NewEnvironment(baseURL string, certificate string) *Environment {
-	return &Environment{baseURL, certificate}
+	rootURL, version := splitVersionedURL(baseURL)
+
+	return &Environment{
+		BaseURL: strings.TrimRight(baseURL, "/"), Certificate: certificate, RootURL: rootURL, APIVersion: version,
+	}
+}
+
+// NewVersionedEnvironment creates a new environment for the given version of the BankID RP API.
+//
+// The root URL may include the path prefix of a gateway, such as https://gateway.example.com/bankid, the versioned
+// path, such as /rp/v6.0, is appended to it.
+func NewVersionedEnvironment(rootURL string, version APIVersion, certificate string) *Environment {
+	rootURL = strings.TrimRight(rootURL, "/")
+
+	return &Environment{
+		BaseURL: rootURL + "/rp/" + version.String(), Certificate: certificate, RootURL: rootURL, APIVersion: version,
+	}
+}
+
+// WithAPIVersion returns a copy of the environment targeting the given version of the BankID RP API.
+func (e *Environment) WithAPIVersion(version APIVersion) *Environment {
+	rootURL := e.RootURL
+	if rootURL == "" {
+		rootURL, _ = splitVersionedURL(e.BaseURL)
+	}
+
+	return NewVersionedEnvironment(rootURL, version, e.Certificate)
+}
+
+// Supports returns true if the BankID RP API of the environment supports endpoints requiring the given version.
+// An environment of unknown version is assumed to support every version.
+func (e *Environment) Supports(version APIVersion) bool {
+	return e.APIVersion.IsZero() || e.APIVersion.AtLeast(version)
 }
@@ -0,0 +1,47 @@
+package configuration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewEnvironmentDerivesAPIVersion(t *testing.T) {
+	assert.Equal(t, "https://appapi2.test.bankid.com", TestEnvironment.RootURL)
+	assert.Equal(t, APIVersion60, TestEnvironment.APIVersion)
+
+	environment := NewEnvironment("https://gateway.example.com/bankid/", "")
+	assert.Equal(t, "https://gateway.example.com/bankid", environment.BaseURL)
+	assert.True(t, environment.APIVersion.IsZero())
+	assert.True(t, environment.Supports(APIVersion{Major: 7, Minor: 0}))
+}
+
+func TestNewVersionedEnvironment(t *testing.T) {
+	version := APIVersion{Major: 6, Minor: 1}
+	environment := NewVersionedEnvironment("https://gateway.example.com/bankid/", version, "")
+
+	assert.Equal(t, "https://gateway.example.com/bankid/rp/v6.1", environment.BaseURL)
+	assert.True(t, environment.Supports(APIVersion60))
+	assert.True(t, environment.Supports(version))
+	assert.False(t, environment.Supports(APIVersion{Major: 6, Minor: 2}))
+
+	production := ProductionEnvironment.WithAPIVersion(version)
+	assert.Equal(t, "https://appapi2.bankid.com/rp/v6.1", production.BaseURL)
+	assert.Equal(t, ProductionEnvironment.Certificate, production.Certificate)
+	assert.Equal(t, "https://appapi2.bankid.com/rp/v6.0", ProductionEnvironment.BaseURL)
+}
+
+func TestParseAPIVersion(t *testing.T) {
+	version, err := ParseAPIVersion("v6.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, APIVersion{Major: 6, Minor: 1}, version)
+	assert.Equal(t, "v6.1", version.String())
+	assert.True(t, version.AtLeast(APIVersion60))
+	assert.False(t, APIVersion60.AtLeast(version))
+
+	_, err = ParseAPIVersion("six")
+	assert.ErrorContains(t, err, `invalid api version "six"`)
+}
@@ -0,0 +1,150 @@
+package configuration
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrCertificateExpired is returned when the client certificate has expired.
+	ErrCertificateExpired = errors.New("client certificate has expired")
+	// ErrCertificateNotYetValid is returned when the validity of the client certificate has not yet started.
+	ErrCertificateNotYetValid = errors.New("client certificate is not yet valid")
+	// ErrCertificateExpiring is reported to the warning hook when the client certificate expires within the warning
+	// window.
+	ErrCertificateExpiring = errors.New("client certificate is about to expire")
+)
+
+// defaultWarningInterval is the minimum interval between two warnings for the same certificate.
+const defaultWarningInterval = 24 * time.Hour
+
+// CertificateInfo contains the metadata of a client certificate.
+type CertificateInfo struct {
+	// The distinguished name of the subject.
+	Subject string
+	// The organisation number of the RP, found in the serial number attribute of the subject.
+	OrganisationNumber string
+	// The serial number of the certificate in hexadecimal form.
+	SerialNumber string
+	// The distinguished name of the issuer.
+	Issuer string
+	// Start of validity of the certificate.
+	NotBefore time.Time
+	// End of validity of the certificate.
+	NotAfter time.Time
+}
+
+// NewCertificateInfo returns the metadata of the certificate.
+func NewCertificateInfo(certificate *x509.Certificate) *CertificateInfo {
+	return &CertificateInfo{
+		Subject:            certificate.Subject.String(),
+		OrganisationNumber: certificate.Subject.SerialNumber,
+		SerialNumber:       fmt.Sprintf("%X", certificate.SerialNumber),
+		Issuer:             certificate.Issuer.String(),
+		NotBefore:          certificate.NotBefore,
+		NotAfter:           certificate.NotAfter,
+	}
+}
+
+// Validate returns ErrCertificateNotYetValid or ErrCertificateExpired if the certificate is not valid at the given
+// time.
+func (c *CertificateInfo) Validate(now time.Time) error {
+	if now.Before(c.NotBefore) {
+		return fmt.Errorf("%w. valid from %s", ErrCertificateNotYetValid, c.NotBefore.Format(time.RFC3339))
+	}
+
+	if now.After(c.NotAfter) {
+		return fmt.Errorf("%w. valid until %s", ErrCertificateExpired, c.NotAfter.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// Remaining returns the time left until the certificate expires, which is negative if it has already expired.
+func (c *CertificateInfo) Remaining(now time.Time) time.Duration {
+	return c.NotAfter.Sub(now)
+}
+
+// LeafCertificate returns the parsed leaf certificate of a tls.Certificate.
+func LeafCertificate(certificate *tls.Certificate) (*x509.Certificate, error) {
+	if certificate.Leaf != nil {
+		return certificate.Leaf, nil
+	}
+
+	if len(certificate.Certificate) == 0 {
+		return nil, errors.New("no certificate in chain")
+	}
+
+	return x509.ParseCertificate(certificate.Certificate[0]) // nolint:wrapcheck
+}
+
+// CertificateWarning is passed to the warning hook of the ExpiryPolicy.
+type CertificateWarning struct {
+	// The certificate the warning concerns.
+	Certificate CertificateInfo
+	// ErrCertificateExpiring, ErrCertificateExpired or ErrCertificateNotYetValid.
+	Reason error
+}
+
+// ExpiryPolicy decides how the validity of the client certificate is checked.
+//
+// The certificate is checked when the client is created and on every TLS handshake. The hook is invoked at most once
+// per WarningInterval for each certificate.
+type ExpiryPolicy struct {
+	// WarningWindow is the duration before the end of validity in which OnWarning is invoked.
+	WarningWindow time.Duration
+	// WarningInterval is the minimum interval between two warnings for the same certificate. Defaults to 24 hours.
+	WarningInterval time.Duration
+	// OnWarning is invoked when the certificate is about to expire, has expired or is not yet valid.
+	OnWarning func(warning CertificateWarning)
+	// RefuseInvalid makes the client refuse to start when the certificate has expired or is not yet valid.
+	RefuseInvalid bool
+
+	mutex  sync.Mutex
+	warned map[string]time.Time
+}
+
+// Check checks the certificate at the given time, invokes the warning hook if needed and returns the validity error
+// of the certificate, if any.
+func (p *ExpiryPolicy) Check(certificate *x509.Certificate, now time.Time) error {
+	info := NewCertificateInfo(certificate)
+
+	err := info.Validate(now)
+
+	reason := err
+	if reason == nil && info.Remaining(now) <= p.WarningWindow {
+		reason = ErrCertificateExpiring
+	}
+
+	if reason != nil && p.OnWarning != nil && p.shouldWarn(info.SerialNumber, now) {
+		p.OnWarning(CertificateWarning{Certificate: *info, Reason: reason})
+	}
+
+	return err
+}
+
+func (p *ExpiryPolicy) shouldWarn(serialNumber string, now time.Time) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	interval := p.WarningInterval
+	if interval == 0 {
+		interval = defaultWarningInterval
+	}
+
+	if last, ok := p.warned[serialNumber]; ok && now.Sub(last) < interval {
+		return false
+	}
+
+	if p.warned == nil {
+		p.warned = map[string]time.Time{}
+	}
+
+	p.warned[serialNumber] = now
+
+	return true
+}
@@ -0,0 +1,80 @@
+package configuration
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpiryPolicyCheck(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		notBefore  time.Time
+		notAfter   time.Time
+		wantErr    error
+		wantReason error
+	}{
+		{name: "Valid", notBefore: now.AddDate(-1, 0, 0), notAfter: now.AddDate(1, 0, 0)},
+		{
+			name: "Expiring", notBefore: now.AddDate(-1, 0, 0), notAfter: now.AddDate(0, 0, 10),
+			wantReason: ErrCertificateExpiring,
+		},
+		{
+			name: "Expired", notBefore: now.AddDate(-1, 0, 0), notAfter: now.AddDate(0, 0, -1),
+			wantErr: ErrCertificateExpired, wantReason: ErrCertificateExpired,
+		},
+		{
+			name: "NotYetValid", notBefore: now.AddDate(0, 0, 5), notAfter: now.AddDate(1, 0, 0),
+			wantErr: ErrCertificateNotYetValid, wantReason: ErrCertificateNotYetValid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var warnings []CertificateWarning
+
+			policy := &ExpiryPolicy{
+				WarningWindow: 30 * 24 * time.Hour,
+				OnWarning:     func(warning CertificateWarning) { warnings = append(warnings, warning) },
+			}
+
+			certificate := &x509.Certificate{
+				SerialNumber: big.NewInt(255),
+				Subject:      pkix.Name{CommonName: "RP", SerialNumber: "5566304928"},
+				NotBefore:    tt.notBefore,
+				NotAfter:     tt.notAfter,
+			}
+
+			err := policy.Check(certificate, now)
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.wantErr)
+			}
+
+			if tt.wantReason == nil {
+				assert.Empty(t, warnings)
+				return
+			}
+
+			assert.Len(t, warnings, 1)
+			assert.True(t, errors.Is(warnings[0].Reason, tt.wantReason))
+			assert.Equal(t, "5566304928", warnings[0].Certificate.OrganisationNumber)
+			assert.Equal(t, "FF", warnings[0].Certificate.SerialNumber)
+
+			// The warning is not repeated within the warning interval.
+			_ = policy.Check(certificate, now.Add(time.Hour))
+			assert.Len(t, warnings, 1)
+
+			_ = policy.Check(certificate, now.Add(25*time.Hour))
+			assert.Len(t, warnings, 2)
+		})
+	}
+}
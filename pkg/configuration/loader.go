@@ -0,0 +1,173 @@
+package configuration
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Pkcs12FromFile reads the PKCS12 file at the given path and verifies that it can be decoded using the password.
+func Pkcs12FromFile(path string, password string) (*Pkcs12, error) {
+	content, err := os.ReadFile(path) // #nosec G304
+	if err != nil {
+		return nil, fmt.Errorf("unable to read pkcs12 file %q. %w", path, err)
+	}
+
+	return newVerifiedPkcs12(content, password)
+}
+
+// Pkcs12FromEnv reads the base64 encoded PKCS12 content from the given environment variable and verifies that it can
+// be decoded using the password.
+func Pkcs12FromEnv(variable string, password string) (*Pkcs12, error) {
+	value, ok := os.LookupEnv(variable)
+	if !ok || value == "" {
+		return nil, fmt.Errorf("environment variable %q with pkcs12 content is not set", variable)
+	}
+
+	content, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, fmt.Errorf("unable to base64 decode pkcs12 from environment variable %q. %w", variable, err)
+	}
+
+	return newVerifiedPkcs12(content, password)
+}
+
+// PasswordFromFile reads a password from the file at the given path, ignoring a trailing line break.
+func PasswordFromFile(path string) (string, error) {
+	content, err := os.ReadFile(path) // #nosec G304
+	if err != nil {
+		return "", fmt.Errorf("unable to read password file %q. %w", path, err)
+	}
+
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// KeyPairFromFiles reads a PEM encoded certificate chain and a PEM encoded private key from the given paths.
+func KeyPairFromFiles(certificatePath string, privateKeyPath string) (*KeyPair, error) {
+	certificatePEM, err := os.ReadFile(certificatePath) // #nosec G304
+	if err != nil {
+		return nil, fmt.Errorf("unable to read certificate file %q. %w", certificatePath, err)
+	}
+
+	privateKeyPEM, err := os.ReadFile(privateKeyPath) // #nosec G304
+	if err != nil {
+		return nil, fmt.Errorf("unable to read private key file %q. %w", privateKeyPath, err)
+	}
+
+	return KeyPairFromPEM(certificatePEM, privateKeyPEM)
+}
+
+// KeyPairFromPEM parses a PEM encoded certificate chain, leaf first, and a PEM encoded PKCS#1, PKCS#8 or EC private
+// key.
+func KeyPairFromPEM(certificatePEM []byte, privateKeyPEM []byte) (*KeyPair, error) {
+	certificates, err := parseCertificates(certificatePEM)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse certificate. %w", err)
+	}
+
+	privateKey, err := parsePrivateKey(privateKeyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse private key. %w", err)
+	}
+
+	return NewKeyPair(certificates, privateKey)
+}
+
+// NewEnvironmentFromCAFiles creates a new environment trusting the CA certificates in the given PEM files.
+func NewEnvironmentFromCAFiles(baseURL string, paths ...string) (*Environment, error) {
+	if len(paths) == 0 {
+		return nil, errors.New("no CA certificate files given")
+	}
+
+	bundle := &bytes.Buffer{}
+
+	for _, path := range paths {
+		content, err := os.ReadFile(path) // #nosec G304
+		if err != nil {
+			return nil, fmt.Errorf("unable to read CA certificate file %q. %w", path, err)
+		}
+
+		if _, err := parseCertificates(content); err != nil {
+			return nil, fmt.Errorf("unable to parse CA certificate file %q. %w", path, err)
+		}
+
+		bundle.Write(content)
+		bundle.WriteString("\n")
+	}
+
+	return NewEnvironment(baseURL, base64.StdEncoding.EncodeToString(bundle.Bytes())), nil
+}
+
+func newVerifiedPkcs12(content []byte, password string) (*Pkcs12, error) {
+	instance := &Pkcs12{Content: content, Password: password}
+
+	if _, err := instance.Certificate(); err != nil {
+		return nil, err
+	}
+
+	return instance, nil
+}
+
+func parseCertificates(content []byte) ([]*x509.Certificate, error) {
+	var certificates []*x509.Certificate
+
+	for block, rest := pem.Decode(content); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err // nolint:wrapcheck
+		}
+
+		certificates = append(certificates, certificate)
+	}
+
+	if len(certificates) == 0 {
+		return nil, errors.New("no PEM encoded certificate found")
+	}
+
+	return certificates, nil
+}
+
+func parsePrivateKey(content []byte) (crypto.Signer, error) {
+	for block, rest := pem.Decode(content); block != nil; block, rest = pem.Decode(rest) {
+		var (
+			key any
+			err error
+		)
+
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		case "ENCRYPTED PRIVATE KEY":
+			return nil, errors.New("encrypted private keys are not supported")
+		default:
+			continue
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s. %w", strings.ToLower(block.Type), err)
+		}
+
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+
+		return signer, nil
+	}
+
+	return nil, errors.New("no PEM encoded private key found")
+}
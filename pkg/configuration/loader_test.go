@@ -0,0 +1,211 @@
+package configuration
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testPkcs12Path = "../resource/certificates/test.p12"
+
+func TestPkcs12FromFile(t *testing.T) {
+	pkcs12, err := Pkcs12FromFile(testPkcs12Path, "qwerty123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certificate, err := pkcs12.Certificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, "FP Testcert 5", certificate.Leaf.Subject.CommonName)
+
+	_, err = Pkcs12FromFile(testPkcs12Path, "invalid")
+	assert.ErrorContains(t, err, "unable to load pkcs12")
+
+	_, err = Pkcs12FromFile("missing.p12", "qwerty123")
+	assert.ErrorContains(t, err, `unable to read pkcs12 file "missing.p12"`)
+}
+
+func TestPkcs12FromEnv(t *testing.T) {
+	content, err := os.ReadFile(testPkcs12Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("BANKID_PKCS12", base64.StdEncoding.EncodeToString(content))
+	t.Setenv("BANKID_PKCS12_INVALID", "not base64")
+
+	pkcs12, err := Pkcs12FromEnv("BANKID_PKCS12", "qwerty123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, content, pkcs12.Content)
+
+	_, err = Pkcs12FromEnv("BANKID_PKCS12_INVALID", "qwerty123")
+	assert.ErrorContains(t, err, "unable to base64 decode pkcs12")
+
+	_, err = Pkcs12FromEnv("BANKID_PKCS12_MISSING", "qwerty123")
+	assert.ErrorContains(t, err, "is not set")
+}
+
+func TestPasswordFromFile(t *testing.T) {
+	path := writeFile(t, "password", []byte("qwerty123\n"))
+
+	password, err := PasswordFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, "qwerty123", password)
+}
+
+func TestKeyPairFromFiles(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ec, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		key   crypto.Signer
+		block *pem.Block
+	}{
+		{name: "PKCS1", key: rsaKey, block: &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}},
+		{name: "PKCS8", key: ecKey, block: &pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}},
+		{name: "EC", key: ecKey, block: &pem.Block{Type: "EC PRIVATE KEY", Bytes: ec}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certificatePath := writeFile(t, "certificate.pem", newCertificatePEM(t, tt.key))
+			privateKeyPath := writeFile(t, "key.pem", pem.EncodeToMemory(tt.block))
+
+			keyPair, err := KeyPairFromFiles(certificatePath, privateKeyPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			certificate, err := keyPair.Certificate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, "RP", certificate.Leaf.Subject.CommonName)
+		})
+	}
+}
+
+func TestKeyPairFromPEMErrors(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherPKCS8, err := x509.MarshalPKCS8PrivateKey(otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certificatePEM := newCertificatePEM(t, key)
+	otherKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: otherPKCS8})
+
+	_, err = KeyPairFromPEM([]byte("garbage"), otherKeyPEM)
+	assert.ErrorContains(t, err, "unable to parse certificate. no PEM encoded certificate found")
+
+	_, err = KeyPairFromPEM(certificatePEM, []byte("garbage"))
+	assert.ErrorContains(t, err, "unable to parse private key. no PEM encoded private key found")
+
+	_, err = KeyPairFromPEM(certificatePEM, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: []byte{1}}))
+	assert.ErrorContains(t, err, "unable to parse private key. invalid ec private key")
+
+	_, err = KeyPairFromPEM(certificatePEM, otherKeyPEM)
+	assert.ErrorContains(t, err, "private key does not match the certificate")
+}
+
+func TestNewEnvironmentFromCAFiles(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	environment, err := NewEnvironmentFromCAFiles("https://bankid.example.com/rp/v6.0",
+		writeFile(t, "ca.pem", newCertificatePEM(t, key)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bundle, err := base64.StdEncoding.DecodeString(environment.Certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.True(t, x509.NewCertPool().AppendCertsFromPEM(bundle))
+
+	_, err = NewEnvironmentFromCAFiles("https://bankid.example.com/rp/v6.0", writeFile(t, "ca.pem", []byte("x")))
+	assert.ErrorContains(t, err, "unable to parse CA certificate file")
+}
+
+func newCertificatePEM(t *testing.T, key crypto.Signer) []byte {
+	t.Helper()
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "RP", SerialNumber: "5566304928"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func writeFile(t *testing.T, name string, content []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
@@ -0,0 +1,211 @@
+package configuration
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// CertificateSource is the interface implemented by types that provide the client certificate presented in the mTLS
+// handshake.
+type CertificateSource interface {
+	// ClientCertificate returns the client certificate to present in the next handshake.
+	ClientCertificate() (*tls.Certificate, error)
+}
+
+// CertificateLoader loads a client certificate.
+type CertificateLoader func() (*tls.Certificate, error)
+
+// Pkcs12FileLoader returns a CertificateLoader reading the PKCS12 file at the given path.
+func Pkcs12FileLoader(path string, password string) CertificateLoader {
+	return func() (*tls.Certificate, error) {
+		pkcs12, err := Pkcs12FromFile(path, password)
+		if err != nil {
+			return nil, err
+		}
+
+		return pkcs12.Certificate()
+	}
+}
+
+// KeyPairFileLoader returns a CertificateLoader reading the PEM encoded certificate chain and private key at the given
+// paths.
+func KeyPairFileLoader(certificatePath string, privateKeyPath string) CertificateLoader {
+	return func() (*tls.Certificate, error) {
+		keyPair, err := KeyPairFromFiles(certificatePath, privateKeyPath)
+		if err != nil {
+			return nil, err
+		}
+
+		return keyPair.Certificate()
+	}
+}
+
+// StaticCertificate is a CertificateSource that always provides the same certificate.
+type StaticCertificate struct {
+	certificate *tls.Certificate
+}
+
+// NewStaticCertificate creates a new static certificate source.
+func NewStaticCertificate(certificate *tls.Certificate) *StaticCertificate {
+	return &StaticCertificate{certificate: certificate}
+}
+
+// ClientCertificate returns the certificate.
+func (s *StaticCertificate) ClientCertificate() (*tls.Certificate, error) {
+	return s.certificate, nil
+}
+
+// ReloadableCertificate is a CertificateSource whose certificate can be replaced while the client is in use.
+//
+// A reload only affects handshakes made after it, connections that are already established, and the requests in
+// flight on them, keep the certificate they were established with.
+type ReloadableCertificate struct {
+	mutex     sync.RWMutex
+	loader    CertificateLoader
+	current   *tls.Certificate
+	listeners []*reloadListener
+}
+
+// reloadListener is a listener registered with OnReload, a pointer identifying the registration.
+type reloadListener struct {
+	listener func(*tls.Certificate)
+}
+
+// NewReloadableCertificate creates a new reloadable certificate and loads the initial certificate using the loader.
+func NewReloadableCertificate(loader CertificateLoader) (*ReloadableCertificate, error) {
+	instance := &ReloadableCertificate{loader: loader}
+
+	if err := instance.Reload(); err != nil {
+		return nil, err
+	}
+
+	return instance, nil
+}
+
+// ClientCertificate returns the most recently loaded certificate.
+func (r *ReloadableCertificate) ClientCertificate() (*tls.Certificate, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.current, nil
+}
+
+// OnReload registers a listener that is invoked with the new certificate after each successful reload. The returned
+// function removes the listener.
+func (r *ReloadableCertificate) OnReload(listener func(*tls.Certificate)) func() {
+	registration := &reloadListener{listener: listener}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.listeners = append(r.listeners, registration)
+
+	return func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+
+		r.listeners = slices.DeleteFunc(r.listeners, func(candidate *reloadListener) bool {
+			return candidate == registration
+		})
+	}
+}
+
+// Reload loads the certificate using the loader. The current certificate is kept if loading fails.
+func (r *ReloadableCertificate) Reload() error {
+	r.mutex.RLock()
+	loader := r.loader
+	r.mutex.RUnlock()
+
+	return r.replace(loader)
+}
+
+// SwitchAt schedules a switch to the certificate loaded by the loader at the given time, which is also used by
+// subsequent reloads. Errors from the scheduled switch are passed to onError, if not nil.
+//
+// The returned function cancels the switch if it has not yet happened.
+func (r *ReloadableCertificate) SwitchAt(at time.Time, loader CertificateLoader, onError func(error)) func() bool {
+	timer := time.AfterFunc(time.Until(at), func() {
+		if err := r.replace(loader); err != nil && onError != nil {
+			onError(err)
+		}
+	})
+
+	return timer.Stop
+}
+
+// Watch polls the given files every interval and reloads the certificate when any of them is modified. Errors from
+// the reloads are passed to onError, if not nil.
+//
+// Watch blocks until the context is done.
+func (r *ReloadableCertificate) Watch(ctx context.Context, interval time.Duration, onError func(error),
+	paths ...string,
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	previous := fileVersions(paths)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			current := fileVersions(paths)
+			if current == previous {
+				continue
+			}
+
+			previous = current
+
+			if err := r.Reload(); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+func (r *ReloadableCertificate) replace(loader CertificateLoader) error {
+	certificate, err := loader()
+	if err != nil {
+		return fmt.Errorf("unable to reload client certificate. %w", err)
+	}
+
+	if certificate == nil {
+		return errors.New("unable to reload client certificate. loader returned no certificate")
+	}
+
+	r.mutex.Lock()
+	r.loader = loader
+	r.current = certificate
+	listeners := slices.Clone(r.listeners)
+	r.mutex.Unlock()
+
+	for _, registration := range listeners {
+		registration.listener(certificate)
+	}
+
+	return nil
+}
+
+// fileVersions returns a string that changes whenever any of the files is modified, created or removed.
+func fileVersions(paths []string) string {
+	version := ""
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			version += path + ":missing;"
+			continue
+		}
+
+		version += fmt.Sprintf("%s:%d:%d;", path, info.ModTime().UnixNano(), info.Size())
+	}
+
+	return version
+}
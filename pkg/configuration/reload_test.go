@@ -0,0 +1,145 @@
+package configuration
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReloadableCertificateReload(t *testing.T) {
+	certificatePath, privateKeyPath := writeKeyPairFiles(t, t.TempDir())
+
+	reloadable, err := NewReloadableCertificate(KeyPairFileLoader(certificatePath, privateKeyPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	initial, _ := reloadable.ClientCertificate()
+
+	var reloaded *tls.Certificate
+
+	remove := reloadable.OnReload(func(certificate *tls.Certificate) { reloaded = certificate })
+
+	writeKeyPairFiles(t, filepath.Dir(certificatePath))
+
+	if err := reloadable.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	current, _ := reloadable.ClientCertificate()
+	assert.NotEqual(t, initial.Leaf.Raw, current.Leaf.Raw)
+	assert.Same(t, current, reloaded)
+
+	if err := os.WriteFile(privateKeyPath, []byte("garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.ErrorContains(t, reloadable.Reload(), "unable to reload client certificate. unable to parse private key")
+
+	kept, _ := reloadable.ClientCertificate()
+	assert.Same(t, current, kept)
+
+	// A removed listener is no longer invoked
+	remove()
+	writeKeyPairFiles(t, filepath.Dir(certificatePath))
+
+	if err := reloadable.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Same(t, current, reloaded)
+}
+
+func TestReloadableCertificateWatch(t *testing.T) {
+	certificatePath, privateKeyPath := writeKeyPairFiles(t, t.TempDir())
+
+	reloadable, err := NewReloadableCertificate(KeyPairFileLoader(certificatePath, privateKeyPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reloads := make(chan *tls.Certificate, 1)
+	reloadable.OnReload(func(certificate *tls.Certificate) { reloads <- certificate })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go reloadable.Watch(ctx, 10*time.Millisecond, func(err error) { t.Error(err) }, certificatePath, privateKeyPath)
+
+	// Ensure that the modification time differs on file systems with coarse timestamps.
+	time.Sleep(20 * time.Millisecond)
+	writeKeyPairFiles(t, filepath.Dir(certificatePath))
+
+	select {
+	case <-reloads:
+	case <-time.After(5 * time.Second):
+		t.Fatal("certificate was not reloaded")
+	}
+}
+
+func TestReloadableCertificateSwitchAt(t *testing.T) {
+	initialCertificatePath, initialPrivateKeyPath := writeKeyPairFiles(t, t.TempDir())
+	nextCertificatePath, nextPrivateKeyPath := writeKeyPairFiles(t, t.TempDir())
+
+	reloadable, err := NewReloadableCertificate(KeyPairFileLoader(initialCertificatePath, initialPrivateKeyPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reloads := make(chan *tls.Certificate, 1)
+	reloadable.OnReload(func(certificate *tls.Certificate) { reloads <- certificate })
+
+	reloadable.SwitchAt(time.Now().Add(10*time.Millisecond), KeyPairFileLoader(nextCertificatePath, nextPrivateKeyPath),
+		func(err error) { t.Error(err) })
+
+	select {
+	case certificate := <-reloads:
+		next, _ := KeyPairFromFiles(nextCertificatePath, nextPrivateKeyPath)
+		assert.Equal(t, next.Certificates[0].Raw, certificate.Leaf.Raw)
+	case <-time.After(5 * time.Second):
+		t.Fatal("certificate was not switched")
+	}
+
+	cancel := reloadable.SwitchAt(time.Now().Add(time.Hour), KeyPairFileLoader(initialCertificatePath,
+		initialPrivateKeyPath), nil)
+	assert.True(t, cancel())
+}
+
+// writeKeyPairFiles writes a new certificate and private key into the directory and returns their paths.
+func writeKeyPairFiles(t *testing.T, directory string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certificatePath := filepath.Join(directory, "certificate.pem")
+	privateKeyPath := filepath.Join(directory, "key.pem")
+	privateKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	if err := os.WriteFile(certificatePath, newCertificatePEM(t, key), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(privateKeyPath, privateKeyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certificatePath, privateKeyPath
+}
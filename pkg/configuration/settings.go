@@ -0,0 +1,559 @@
+package configuration
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// envPrefix is the prefix of the environment variables read by FromEnv.
+const envPrefix = "BANKID_"
+
+// The keys of the settings read by FromEnv and FromFile. Environment variables use the key in upper snake case
+// prefixed with BANKID_, such as BANKID_BASE_URL for baseUrl and BANKID_FEATURES_REFUSE_INVALID_CERTIFICATE for
+// features.refuseInvalidCertificate.
+const (
+	// SettingEnvironment selects the environment, one of test, production or custom.
+	SettingEnvironment = "environment"
+	// SettingBaseURL is the base URL of a custom environment.
+	SettingBaseURL = "baseUrl"
+	// SettingAPIVersion is the version of the BankID RP API, such as v6.0. Defaults to the version of the environment.
+	SettingAPIVersion = "apiVersion"
+	// SettingCAFiles is a comma separated list of PEM files with the CA certificates of a custom environment.
+	SettingCAFiles = "caFiles"
+	// SettingPkcs12File is the path of the PKCS12 file.
+	SettingPkcs12File = "pkcs12File"
+	// SettingPkcs12 is the base64 encoded PKCS12 content.
+	SettingPkcs12 = "pkcs12"
+	// SettingPassword is the password of the PKCS12.
+	SettingPassword = "password"
+	// SettingPasswordFile is the path of a file containing the password of the PKCS12.
+	SettingPasswordFile = "passwordFile"
+	// SettingCertificateFile is the path of the PEM encoded certificate chain.
+	SettingCertificateFile = "certificateFile"
+	// SettingPrivateKeyFile is the path of the PEM encoded private key.
+	SettingPrivateKeyFile = "privateKeyFile"
+	// SettingProxy is the URL of the proxy, or "environment" to use the HTTPS_PROXY and NO_PROXY environment
+	// variables.
+	SettingProxy = "proxy"
+	// SettingTimeout is the timeout of the requests to the BankID RP API, such as 10s.
+	SettingTimeout = "timeout"
+	// SettingHealthCacheInterval is the duration the outcome of a health check is cached, such as 30s.
+	SettingHealthCacheInterval = "healthCacheInterval"
+	// SettingRefuseInvalidCertificate refuses to start with an expired or not yet valid certificate when true.
+	SettingRefuseInvalidCertificate = "features.refuseInvalidCertificate"
+)
+
+// The environments that can be selected using SettingEnvironment.
+const (
+	EnvironmentTest       = "test"
+	EnvironmentProduction = "production"
+	EnvironmentCustom     = "custom"
+)
+
+var knownSettings = []string{
+	SettingEnvironment, SettingBaseURL, SettingAPIVersion, SettingCAFiles, SettingPkcs12File, SettingPkcs12,
+	SettingPassword, SettingPasswordFile, SettingCertificateFile, SettingPrivateKeyFile, SettingProxy, SettingTimeout,
+	SettingHealthCacheInterval, SettingRefuseInvalidCertificate,
+}
+
+// FromEnv creates a new configuration from the BANKID_ prefixed environment variables.
+//
+// All problems with the settings are reported at once, joined together in the returned error.
+func FromEnv(options ...Option) (*Configuration, error) {
+	values := map[string]string{}
+
+	for _, key := range knownSettings {
+		if value, ok := os.LookupEnv(envVariable(key)); ok {
+			values[key] = value
+		}
+	}
+
+	return fromSettings(values, envVariable, options...)
+}
+
+// FromFile creates a new configuration from a settings file.
+//
+// Files with the .json extension are read as JSON, other files as a YAML-like format of "key: value" lines where
+// nested keys are indented, lists are written as "- item" lines, values may be quoted with " or ' and # starts a
+// comment outside of a quoted value. Relative paths in the file are resolved against the directory of the file.
+//
+// All problems with the settings are reported at once, joined together in the returned error.
+func FromFile(path string, options ...Option) (*Configuration, error) {
+	content, err := os.ReadFile(path) // #nosec G304
+	if err != nil {
+		return nil, fmt.Errorf("unable to read configuration file %q. %w", path, err)
+	}
+
+	var values map[string]string
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		values, err = parseJSONSettings(content)
+	} else {
+		values, err = parseYAMLSettings(content)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse configuration file %q. %w", path, err)
+	}
+
+	for _, key := range []string{SettingCAFiles, SettingPkcs12File, SettingPasswordFile, SettingCertificateFile,
+		SettingPrivateKeyFile} {
+		if value, ok := values[key]; ok {
+			values[key] = resolvePaths(filepath.Dir(path), value)
+		}
+	}
+
+	return fromSettings(values, func(key string) string { return key }, options...)
+}
+
+// fromSettings validates the settings and creates the configuration. The name function returns the name of a
+// setting as presented in errors.
+func fromSettings(values map[string]string, name func(string) string, options ...Option) (*Configuration, error) {
+	var errs []error
+
+	fail := func(key string, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: "+format, append([]any{name(key)}, args...)...))
+	}
+
+	unknown := make([]string, 0)
+
+	for key := range values {
+		if !slices.Contains(knownSettings, key) {
+			unknown = append(unknown, key)
+		}
+	}
+
+	sort.Strings(unknown)
+
+	for _, key := range unknown {
+		fail(key, "unknown setting")
+	}
+
+	environment := environmentFromSettings(values, fail)
+
+	if value := values[SettingAPIVersion]; value != "" && environment != nil {
+		version, err := ParseAPIVersion(value)
+		if err != nil {
+			fail(SettingAPIVersion, "%v", err)
+		} else {
+			environment = environment.WithAPIVersion(version)
+		}
+	}
+
+	configuration := NewConfiguration(environment, nil)
+
+	certificateFromSettings(configuration, values, fail)
+
+	proxyFromSettings(configuration, values, fail)
+
+	configuration.Timeout = durationSetting(values, SettingTimeout, 0, fail)
+	configuration.HealthCacheInterval = durationSetting(values, SettingHealthCacheInterval,
+		defaultHealthCacheInterval, fail)
+
+	if refuse := boolSetting(values, SettingRefuseInvalidCertificate, fail); refuse {
+		configuration.ExpiryPolicy = &ExpiryPolicy{RefuseInvalid: true}
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid configuration. %w", errors.Join(errs...))
+	}
+
+	for _, option := range options {
+		option(configuration)
+	}
+
+	return configuration, nil
+}
+
+func environmentFromSettings(values map[string]string, fail func(string, string, ...any)) *Environment {
+	baseURL, hasBaseURL := values[SettingBaseURL]
+	caFiles := splitList(values[SettingCAFiles])
+
+	selected := values[SettingEnvironment]
+	if selected == "" && hasBaseURL {
+		selected = EnvironmentCustom
+	}
+
+	switch selected {
+	case EnvironmentTest, EnvironmentProduction:
+		if hasBaseURL || len(caFiles) > 0 {
+			fail(SettingEnvironment, "%s and %s are only allowed with the %s environment", SettingBaseURL,
+				SettingCAFiles, EnvironmentCustom)
+		}
+
+		if selected == EnvironmentTest {
+			return TestEnvironment
+		}
+
+		return ProductionEnvironment
+	case EnvironmentCustom:
+		if parsed, err := url.Parse(baseURL); err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+			fail(SettingBaseURL, "must be an absolute https URL, got %q", baseURL)
+		}
+
+		if len(caFiles) == 0 {
+			fail(SettingCAFiles, "is required with the %s environment", EnvironmentCustom)
+
+			return nil
+		}
+
+		environment, err := NewEnvironmentFromCAFiles(strings.TrimRight(baseURL, "/"), caFiles...)
+		if err != nil {
+			fail(SettingCAFiles, "%v", err)
+		}
+
+		return environment
+	case "":
+		fail(SettingEnvironment, "is required")
+	default:
+		fail(SettingEnvironment, "must be one of %s, %s or %s, got %q", EnvironmentTest, EnvironmentProduction,
+			EnvironmentCustom, selected)
+	}
+
+	return nil
+}
+
+func certificateFromSettings(configuration *Configuration, values map[string]string,
+	fail func(string, string, ...any),
+) {
+	sources := 0
+
+	for _, key := range []string{SettingPkcs12File, SettingPkcs12, SettingCertificateFile} {
+		if values[key] != "" {
+			sources++
+		}
+	}
+
+	if sources != 1 {
+		fail(SettingPkcs12File, "exactly one of %s, %s or %s and %s is required", SettingPkcs12File, SettingPkcs12,
+			SettingCertificateFile, SettingPrivateKeyFile)
+
+		return
+	}
+
+	if values[SettingCertificateFile] != "" {
+		if values[SettingPrivateKeyFile] == "" {
+			fail(SettingPrivateKeyFile, "is required with %s", SettingCertificateFile)
+
+			return
+		}
+
+		keyPair, err := KeyPairFromFiles(values[SettingCertificateFile], values[SettingPrivateKeyFile])
+		if err != nil {
+			fail(SettingCertificateFile, "%v", err)
+		}
+
+		configuration.KeyPair = keyPair
+
+		return
+	}
+
+	password, ok := passwordFromSettings(values, fail)
+	if !ok {
+		return
+	}
+
+	var (
+		pkcs12 *Pkcs12
+		err    error
+	)
+
+	if values[SettingPkcs12File] != "" {
+		pkcs12, err = Pkcs12FromFile(values[SettingPkcs12File], password)
+		if err != nil {
+			fail(SettingPkcs12File, "%v", err)
+		}
+	} else {
+		pkcs12, err = newPkcs12FromBase64(values[SettingPkcs12], password)
+		if err != nil {
+			fail(SettingPkcs12, "%v", err)
+		}
+	}
+
+	configuration.Pkcs12 = pkcs12
+}
+
+func passwordFromSettings(values map[string]string, fail func(string, string, ...any)) (string, bool) {
+	password, hasPassword := values[SettingPassword]
+	passwordFile := values[SettingPasswordFile]
+
+	switch {
+	case hasPassword && passwordFile != "":
+		fail(SettingPassword, "only one of %s and %s is allowed", SettingPassword, SettingPasswordFile)
+	case passwordFile != "":
+		password, err := PasswordFromFile(passwordFile)
+		if err != nil {
+			fail(SettingPasswordFile, "%v", err)
+
+			return "", false
+		}
+
+		return password, true
+	case hasPassword:
+		return password, true
+	default:
+		fail(SettingPassword, "one of %s and %s is required", SettingPassword, SettingPasswordFile)
+	}
+
+	return "", false
+}
+
+func proxyFromSettings(configuration *Configuration, values map[string]string, fail func(string, string, ...any)) {
+	value := values[SettingProxy]
+
+	switch value {
+	case "":
+	case "environment":
+		WithProxyFromEnvironment()(configuration)
+	default:
+		proxyURL, err := url.Parse(value)
+		if err != nil || proxyURL.Host == "" || (proxyURL.Scheme != "http" && proxyURL.Scheme != "https") {
+			// The value is left out of the error as it may hold credentials
+			fail(SettingProxy, `must be an absolute http or https URL or "environment"`)
+
+			return
+		}
+
+		WithProxy(proxyURL)(configuration)
+	}
+}
+
+func durationSetting(values map[string]string, key string, fallback time.Duration,
+	fail func(string, string, ...any),
+) time.Duration {
+	value, ok := values[key]
+	if !ok || value == "" {
+		return fallback
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		fail(key, "must be a non-negative duration such as 10s, got %q", value)
+
+		return fallback
+	}
+
+	return duration
+}
+
+func boolSetting(values map[string]string, key string, fail func(string, string, ...any)) bool {
+	value, ok := values[key]
+	if !ok || value == "" {
+		return false
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		fail(key, "must be true or false, got %q", value)
+	}
+
+	return parsed
+}
+
+// parseJSONSettings flattens a JSON object into settings, nested keys are joined by a dot and lists by a comma.
+func parseJSONSettings(content []byte) (map[string]string, error) {
+	var document map[string]any
+
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err // nolint:wrapcheck
+	}
+
+	values := map[string]string{}
+	flattenJSON("", document, values)
+
+	return values, nil
+}
+
+func flattenJSON(prefix string, document map[string]any, values map[string]string) {
+	for key, value := range document {
+		switch typed := value.(type) {
+		case map[string]any:
+			flattenJSON(prefix+key+".", typed, values)
+		case []any:
+			items := make([]string, 0, len(typed))
+			for _, item := range typed {
+				items = append(items, fmt.Sprint(item))
+			}
+
+			values[prefix+key] = strings.Join(items, ",")
+		case nil:
+			values[prefix+key] = ""
+		default:
+			values[prefix+key] = fmt.Sprint(typed)
+		}
+	}
+}
+
+// parseYAMLSettings parses the YAML-like settings format described by FromFile.
+func parseYAMLSettings(content []byte) (map[string]string, error) {
+	values := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	parent, last := "", ""
+
+	for number := 1; scanner.Scan(); number++ {
+		line := stripComment(scanner.Text())
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		indented := unicode.IsSpace(rune(line[0]))
+		line = strings.TrimSpace(line)
+
+		if item, ok := strings.CutPrefix(line, "- "); ok {
+			if last == "" {
+				return nil, fmt.Errorf("line %d: list item without key", number)
+			}
+
+			item, err := unquote(item)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", number, err)
+			}
+
+			values[last] = strings.TrimPrefix(values[last]+","+item, ",")
+
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", number)
+		}
+
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		if !indented {
+			parent = ""
+		}
+
+		if value == "" && !indented {
+			parent, last = key+".", key
+			values[key] = ""
+
+			continue
+		}
+
+		value, err := unquote(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", number, err)
+		}
+
+		delete(values, strings.TrimSuffix(parent, "."))
+
+		last = parent + key
+		values[last] = value
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err // nolint:wrapcheck
+	}
+
+	return values, nil
+}
+
+// envVariable returns the environment variable of a setting key, such as BANKID_BASE_URL for baseUrl.
+func envVariable(key string) string {
+	var builder strings.Builder
+
+	builder.WriteString(envPrefix)
+
+	for i, r := range key {
+		switch {
+		case r == '.':
+			builder.WriteRune('_')
+		case unicode.IsUpper(r) && i > 0 && key[i-1] != '.':
+			builder.WriteRune('_')
+			builder.WriteRune(r)
+		default:
+			builder.WriteRune(unicode.ToUpper(r))
+		}
+	}
+
+	return builder.String()
+}
+
+func newPkcs12FromBase64(value string, password string) (*Pkcs12, error) {
+	content, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, fmt.Errorf("unable to base64 decode pkcs12. %w", err)
+	}
+
+	return newVerifiedPkcs12(content, password)
+}
+
+func resolvePaths(directory string, value string) string {
+	paths := splitList(value)
+	for i, path := range paths {
+		if !filepath.IsAbs(path) {
+			paths[i] = filepath.Join(directory, path)
+		}
+	}
+
+	return strings.Join(paths, ",")
+}
+
+func splitList(value string) []string {
+	var items []string
+
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// stripComment removes the comment of the line, which starts with a # at the start of the line or after a space,
+// outside of a quoted value. A quote starts a quoted value at the start of the line, or after a space or a colon.
+func stripComment(line string) string {
+	var quote byte
+
+	for index := range len(line) {
+		character := line[index]
+		separated := index == 0 || unicode.IsSpace(rune(line[index-1]))
+
+		switch {
+		case quote != 0:
+			if character == quote {
+				quote = 0
+			}
+		case character == '"' || character == '\'':
+			if separated || line[index-1] == ':' {
+				quote = character
+			}
+		case character == '#' && separated:
+			return line[:index]
+		}
+	}
+
+	return line
+}
+
+// unquote removes the quotes of a quoted value, it returns an error if the quotes of the value are not closed.
+func unquote(value string) (string, error) {
+	if value == "" || (value[0] != '"' && value[0] != '\'') {
+		return value, nil
+	}
+
+	if len(value) < 2 || value[len(value)-1] != value[0] {
+		return "", errors.New("unterminated quoted value")
+	}
+
+	return value[1 : len(value)-1], nil
+}
@@ -0,0 +1,91 @@
+package configuration
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// ErrCertificatePinMismatch is returned when no certificate of the verified server chain matches a pin.
+var ErrCertificatePinMismatch = errors.New("server certificate does not match any pin")
+
+// TLSPolicy contains the policy of the TLS connections to the BankID RP API.
+type TLSPolicy struct {
+	// The minimum TLS version, defaults to TLS 1.2.
+	MinVersion uint16
+	// The allowed cipher suites of TLS 1.2 and earlier, defaults to the cipher suites of crypto/tls. The cipher suites
+	// of TLS 1.3 are not configurable.
+	CipherSuites []uint16
+	// The base64 encoded SHA-256 hashes of the SubjectPublicKeyInfo of the server certificates, see SPKIPin. At least
+	// one certificate of the verified server chain must match a pin or a backup pin.
+	Pins []string
+	// The pins of keys that are not yet in use, which keep the connection working when the server keys are rotated.
+	// Required when Pins is set.
+	BackupPins []string
+	// VerifyChain is invoked with the verified server chains after the pins have been checked, the connection is
+	// refused if it returns an error.
+	VerifyChain func(verifiedChains [][]*x509.Certificate) error
+}
+
+// SPKIPin returns the base64 encoded SHA-256 hash of the SubjectPublicKeyInfo of the certificate.
+func SPKIPin(certificate *x509.Certificate) string {
+	hash := sha256.Sum256(certificate.RawSubjectPublicKeyInfo)
+
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// Validate returns an error if the policy is inconsistent.
+func (p *TLSPolicy) Validate() error {
+	if p.MinVersion != 0 && p.MinVersion < tls.VersionTLS12 {
+		return fmt.Errorf("minimum tls version %s is not allowed", tls.VersionName(p.MinVersion))
+	}
+
+	for _, id := range p.CipherSuites {
+		if !slices.ContainsFunc(tls.CipherSuites(), func(suite *tls.CipherSuite) bool { return suite.ID == id }) {
+			return fmt.Errorf("cipher suite %s is not allowed", tls.CipherSuiteName(id))
+		}
+	}
+
+	if len(p.Pins) > 0 && len(p.BackupPins) == 0 {
+		return errors.New("backup pins are required when pinning")
+	}
+
+	for _, pin := range slices.Concat(p.Pins, p.BackupPins) {
+		if decoded, err := base64.StdEncoding.DecodeString(pin); err != nil || len(decoded) != sha256.Size {
+			return fmt.Errorf("pin %q is not a base64 encoded SHA-256 hash", pin)
+		}
+	}
+
+	return nil
+}
+
+// VerifyConnection checks the verified server chains of the connection against the pins and the VerifyChain hook.
+func (p *TLSPolicy) VerifyConnection(state tls.ConnectionState) error {
+	if len(p.Pins) > 0 && !p.matchesPin(state.VerifiedChains) {
+		return ErrCertificatePinMismatch
+	}
+
+	if p.VerifyChain != nil {
+		return p.VerifyChain(state.VerifiedChains)
+	}
+
+	return nil
+}
+
+func (p *TLSPolicy) matchesPin(verifiedChains [][]*x509.Certificate) bool {
+	pins := slices.Concat(p.Pins, p.BackupPins)
+
+	for _, chain := range verifiedChains {
+		for _, certificate := range chain {
+			if slices.Contains(pins, SPKIPin(certificate)) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
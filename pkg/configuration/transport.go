@@ -0,0 +1,60 @@
+package configuration
+
+import "time"
+
+// The defaults of the TransportPolicy.
+const (
+	defaultMaxIdleConnections = 8
+	defaultIdleTimeout        = 90 * time.Second
+	defaultKeepAlive          = 30 * time.Second
+	defaultHandshakeTimeout   = 10 * time.Second
+	defaultSessionCacheSize   = 32
+	defaultWarmUpConnections  = 1
+)
+
+// TransportPolicy contains the tuning of the connections to the BankID RP API.
+//
+// The zero value of a field selects its default.
+type TransportPolicy struct {
+	// The maximum number of idle connections kept to the BankID RP API, defaults to 8.
+	MaxIdleConnections int
+	// The time an idle connection is kept before it is closed, defaults to 90 seconds.
+	IdleTimeout time.Duration
+	// The interval of the TCP keep-alive probes, defaults to 30 seconds.
+	KeepAlive time.Duration
+	// The timeout of the TLS handshake, defaults to 10 seconds.
+	HandshakeTimeout time.Duration
+	// DisableHTTP2 makes the client use HTTP/1.1 only. HTTP/2 multiplexes the requests over a single connection, which
+	// saves the handshakes of new connections under load.
+	DisableHTTP2 bool
+	// The number of TLS sessions cached for resumption, which makes the handshake of a new connection cheaper.
+	// Defaults to 32, a negative size disables resumption.
+	SessionCacheSize int
+	// The number of connections established by warm-up, defaults to 1. A single connection is enough with HTTP/2.
+	WarmUpConnections int
+}
+
+// WithDefaults returns a copy of the policy where the zero values are replaced by their defaults.
+func (p *TransportPolicy) WithDefaults() TransportPolicy {
+	policy := TransportPolicy{}
+	if p != nil {
+		policy = *p
+	}
+
+	policy.MaxIdleConnections = orDefault(policy.MaxIdleConnections, defaultMaxIdleConnections)
+	policy.IdleTimeout = orDefault(policy.IdleTimeout, defaultIdleTimeout)
+	policy.KeepAlive = orDefault(policy.KeepAlive, defaultKeepAlive)
+	policy.HandshakeTimeout = orDefault(policy.HandshakeTimeout, defaultHandshakeTimeout)
+	policy.SessionCacheSize = orDefault(policy.SessionCacheSize, defaultSessionCacheSize)
+	policy.WarmUpConnections = orDefault(policy.WarmUpConnections, defaultWarmUpConnections)
+
+	return policy
+}
+
+func orDefault[T int | time.Duration](value T, fallback T) T {
+	if value == 0 {
+		return fallback
+	}
+
+	return value
+}
@@ -0,0 +1,70 @@
+package configuration
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// APIVersion is a version of the BankID RP API. The zero value is an unknown version.
+type APIVersion struct {
+	Major int
+	Minor int
+}
+
+// APIVersion60 is version 6.0 of the BankID RP API.
+var APIVersion60 = APIVersion{Major: 6, Minor: 0}
+
+// versionPathPattern matches the versioned path of the BankID RP API at the end of a URL, such as /rp/v6.0.
+var versionPathPattern = regexp.MustCompile(`/rp/v(\d+)\.(\d+)/?$`)
+
+// ParseAPIVersion parses a version such as "v6.0" or "6.1".
+func ParseAPIVersion(version string) (APIVersion, error) {
+	major, minor, ok := strings.Cut(strings.TrimPrefix(strings.TrimSpace(version), "v"), ".")
+	if !ok {
+		return APIVersion{}, fmt.Errorf("invalid api version %q. expected major.minor", version)
+	}
+
+	majorNumber, majorErr := strconv.Atoi(major)
+	minorNumber, minorErr := strconv.Atoi(minor)
+
+	if majorErr != nil || minorErr != nil || majorNumber < 0 || minorNumber < 0 {
+		return APIVersion{}, fmt.Errorf("invalid api version %q. expected major.minor", version)
+	}
+
+	return APIVersion{Major: majorNumber, Minor: minorNumber}, nil
+}
+
+// String returns the version as used in the path of the BankID RP API, such as "v6.0".
+func (v APIVersion) String() string {
+	return fmt.Sprintf("v%d.%d", v.Major, v.Minor)
+}
+
+// IsZero returns true if the version is unknown.
+func (v APIVersion) IsZero() bool {
+	return v == APIVersion{}
+}
+
+// AtLeast returns true if the version is the same as or later than the other version.
+func (v APIVersion) AtLeast(other APIVersion) bool {
+	if v.Major != other.Major {
+		return v.Major > other.Major
+	}
+
+	return v.Minor >= other.Minor
+}
+
+// splitVersionedURL splits a URL ending with a versioned path, such as https://appapi2.bankid.com/rp/v6.0, into the
+// root URL and the version. URLs without a versioned path are returned as is with an unknown version.
+func splitVersionedURL(baseURL string) (string, APIVersion) {
+	matches := versionPathPattern.FindStringSubmatchIndex(baseURL)
+	if matches == nil {
+		return strings.TrimRight(baseURL, "/"), APIVersion{}
+	}
+
+	major, _ := strconv.Atoi(baseURL[matches[2]:matches[3]])
+	minor, _ := strconv.Atoi(baseURL[matches[4]:matches[5]])
+
+	return baseURL[:matches[0]], APIVersion{Major: major, Minor: minor}
+}
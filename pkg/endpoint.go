@@ -0,0 +1,41 @@
+package pkg
+
+import (
+	"github.com/e-identification/bankid-go/pkg/configuration"
+	"github.com/e-identification/bankid-go/pkg/internal/http"
+)
+
+// endpoint describes an endpoint of the BankID RP API.
+type endpoint struct {
+	// The path of the endpoint relative to the base URL of the environment.
+	uri string
+	// The first version of the BankID RP API providing the endpoint.
+	minVersion configuration.APIVersion
+}
+
+// The endpoints of the BankID RP API.
+var (
+	authEndpoint      = endpoint{uri: "auth", minVersion: configuration.APIVersion60}
+	phoneAuthEndpoint = endpoint{uri: "phone/auth", minVersion: configuration.APIVersion60}
+	signEndpoint      = endpoint{uri: "sign", minVersion: configuration.APIVersion60}
+	phoneSignEndpoint = endpoint{uri: "phone/sign", minVersion: configuration.APIVersion60}
+	collectEndpoint   = endpoint{uri: "collect", minVersion: configuration.APIVersion60}
+	cancelEndpoint    = endpoint{uri: "cancel", minVersion: configuration.APIVersion60}
+)
+
+// request creates a request to the endpoint.
+func (e endpoint) request(payload http.Payload, response http.Response) *http.Request {
+	return &http.Request{
+		URI: e.uri, MinVersion: e.minVersion, Payload: payload, Response: response, ErrorResponse: &APIError{},
+	}
+}
+
+// startsOrder returns true if a request to the URI starts a new order.
+func startsOrder(uri string) bool {
+	switch uri {
+	case authEndpoint.uri, phoneAuthEndpoint.uri, signEndpoint.uri, phoneSignEndpoint.uri:
+		return true
+	default:
+		return false
+	}
+}
@@ -1,6 +1,24 @@
 package pkg
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/e-identification/bankid-go/pkg/response"
+)
+
+var (
+	// ErrEnvironmentMismatch is returned when the client certificate is not issued for the selected environment.
+	ErrEnvironmentMismatch = errors.New("client certificate does not match the environment")
+	// ErrUnsupportedAPIVersion is returned when an endpoint requires a later version of the BankID RP API than the
+	// environment uses.
+	ErrUnsupportedAPIVersion = errors.New("endpoint is not supported by the api version")
+	// ErrClientShutdown is returned when an order is started after the client has been shut down.
+	ErrClientShutdown = errors.New("client is shut down")
+)
 
 // A ValidationError is returned when the payload is found to be invalid.
 type ValidationError struct {
@@ -23,6 +41,19 @@ func (v ValidationError) Unwrap() error {
 	return v.wrapped
 }
 
+// A SignerMismatchError is returned when an order of a step-up is signed by another user than the authenticated user,
+// see StepUp.
+type SignerMismatchError struct {
+	// The personal number of the authenticated user.
+	Expected string
+	// The personal number of the user that signed the order.
+	Actual string
+}
+
+func (s SignerMismatchError) Error() string {
+	return fmt.Sprintf("order was signed by %s, expected the authenticated user %s", s.Actual, s.Expected)
+}
+
 // A APIError is returned when the BankID RP API returns an error.
 type APIError struct {
 	ErrorCode string `json:"errorCode"`
@@ -32,3 +63,26 @@ type APIError struct {
 func (e APIError) Error() string {
 	return fmt.Sprintf("%s. %s", e.ErrorCode, e.Details)
 }
+
+// IsTemporary returns true if the call that returned the error may succeed when retried, which is the case for
+// network errors, timeouts and for the requestTimeout, internalError and maintenance errors of the BankID RP API.
+// Other errors, such as a response that cannot be decoded or a refused client certificate, are not temporary.
+func IsTemporary(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var apiError *APIError
+	if errors.As(err, &apiError) {
+		switch response.ErrorCode(apiError.ErrorCode) {
+		case response.ErrorRequestTimeout, response.ErrorInternalError, response.ErrorMaintenance:
+			return true
+		default:
+			return false
+		}
+	}
+
+	var netError net.Error
+
+	return errors.As(err, &netError) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,99 @@
+// Package fault provides a fault injecting http.RoundTripper for resilience testing against the BankID RP API.
+package fault
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Fault is the function invoked instead of, or around, the next http.RoundTripper when a Rule applies.
+type Fault func(request *http.Request, next http.RoundTripper) (*http.Response, error)
+
+// Latency returns a Fault that delays the request by the given duration before it is forwarded.
+//
+// The delay is aborted with the context error if the request context is done before the duration has passed.
+func Latency(duration time.Duration) Fault {
+	return func(request *http.Request, next http.RoundTripper) (*http.Response, error) {
+		timer := time.NewTimer(duration)
+		defer timer.Stop()
+
+		select {
+		case <-request.Context().Done():
+			return nil, request.Context().Err() // nolint:wrapcheck
+		case <-timer.C:
+			return next.RoundTrip(request) // nolint:wrapcheck
+		}
+	}
+}
+
+// Status returns a Fault that responds with the given status code, content type and body without forwarding the
+// request.
+func Status(statusCode int, contentType string, body string) Fault {
+	return func(request *http.Request, _ http.RoundTripper) (*http.Response, error) {
+		header := http.Header{}
+		if contentType != "" {
+			header.Set("Content-Type", contentType)
+		}
+
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+			StatusCode:    statusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          io.NopCloser(strings.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       request,
+		}, nil
+	}
+}
+
+// Maintenance returns a Fault that responds as the BankID RP API does when it is temporarily unavailable.
+func Maintenance() Fault {
+	return Status(http.StatusServiceUnavailable, "application/json",
+		`{"errorCode":"maintenance","details":"Service temporarily unavailable"}`)
+}
+
+// NonJSON returns a Fault that responds with an HTML body, as the BankID RP API does when the certificate is invalid.
+func NonJSON(statusCode int) Fault {
+	status := fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode))
+
+	return Status(statusCode, "text/html",
+		"<html>\n<head><title>"+status+"</title></head>\n<body><center><h1>"+status+"</h1></center></body>\n</html>")
+}
+
+// ConnectionReset returns a Fault that fails the request as if the connection was reset by the peer.
+func ConnectionReset() Fault {
+	return func(request *http.Request, _ http.RoundTripper) (*http.Response, error) {
+		return nil, &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+	}
+}
+
+// Truncate returns a Fault that forwards the request and truncates the response body to the given number of bytes.
+func Truncate(length int) Fault {
+	return func(request *http.Request, next http.RoundTripper) (*http.Response, error) {
+		response, err := next.RoundTrip(request)
+		if err != nil {
+			return nil, err // nolint:wrapcheck
+		}
+
+		defer response.Body.Close() // nolint:errcheck
+
+		body, err := io.ReadAll(io.LimitReader(response.Body, int64(length)))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the response body. %w", err)
+		}
+
+		response.Body = io.NopCloser(bytes.NewReader(body))
+		response.ContentLength = int64(len(body))
+
+		return response, nil
+	}
+}
@@ -0,0 +1,143 @@
+package fault
+
+import (
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rule describes when a Fault is injected.
+type Rule struct {
+	// The endpoint the rule applies to, such as "collect" or "phone/auth". An empty endpoint matches every request.
+	Endpoint string
+	// The probability, between 0 and 1, that a matching request is faulted. Zero means that every matching request
+	// is faulted.
+	Probability float64
+	// The number of matching requests to let through before the rule starts to apply.
+	Skip int
+	// The maximum number of faults injected by the rule. Zero means unlimited.
+	Times int
+	// The fault to inject.
+	Fault Fault
+}
+
+// rule holds a Rule and the number of requests it has matched and faulted.
+type rule struct {
+	Rule
+	matched  int
+	injected int
+}
+
+// Transport is a http.RoundTripper that injects faults into requests according to its rules.
+//
+// The rules are evaluated in order and the first rule that applies decides the fault. Requests that no rule applies
+// to are forwarded to the next http.RoundTripper unchanged.
+type Transport struct {
+	next   http.RoundTripper
+	rules  []*rule
+	random *rand.Rand
+	mutex  sync.Mutex
+}
+
+// Option definition.
+type Option func(*Transport)
+
+// NewTransport returns a new instance of 'Transport' forwarding to next, or http.DefaultTransport if next is nil.
+func NewTransport(next http.RoundTripper, options ...Option) *Transport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	// #nosec G404
+	instance := &Transport{next: next, random: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+	// Apply options if there are any, can overwrite default
+	for _, option := range options {
+		option(instance)
+	}
+
+	return instance
+}
+
+// WithRule Function to create Option func to add a rule.
+func WithRule(target Rule) Option {
+	return func(subject *Transport) {
+		subject.rules = append(subject.rules, &rule{Rule: target})
+	}
+}
+
+// WithSeed Function to create Option func to set the seed of the random source, making probabilistic faults
+// reproducible.
+func WithSeed(seed int64) Option {
+	return func(subject *Transport) {
+		subject.random = rand.New(rand.NewSource(seed)) // #nosec G404
+	}
+}
+
+// Wrapper returns a function that wraps a http.RoundTripper in a new Transport, suitable for
+// configuration.WithTransportWrapper.
+func Wrapper(options ...Option) func(http.RoundTripper) http.RoundTripper {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return NewTransport(next, options...)
+	}
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *Transport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if fault := t.faultFor(request); fault != nil {
+		return fault(request, t.next)
+	}
+
+	return t.next.RoundTrip(request) // nolint:wrapcheck
+}
+
+// Injected returns the number of faults injected so far.
+func (t *Transport) Injected() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	injected := 0
+	for _, rule := range t.rules {
+		injected += rule.injected
+	}
+
+	return injected
+}
+
+// faultFor returns the fault of the first rule that applies to the request, or nil if there is none.
+func (t *Transport) faultFor(request *http.Request) Fault {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for _, rule := range t.rules {
+		if !matchesEndpoint(request, rule.Endpoint) {
+			continue
+		}
+
+		rule.matched++
+
+		if rule.matched <= rule.Skip || (rule.Times > 0 && rule.injected >= rule.Times) {
+			continue
+		}
+
+		if rule.Probability > 0 && t.random.Float64() >= rule.Probability {
+			continue
+		}
+
+		rule.injected++
+
+		return rule.Fault
+	}
+
+	return nil
+}
+
+func matchesEndpoint(request *http.Request, endpoint string) bool {
+	if endpoint == "" {
+		return true
+	}
+
+	return strings.HasSuffix(request.URL.Path, "/"+strings.TrimPrefix(endpoint, "/"))
+}
@@ -0,0 +1,116 @@
+package fault
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type roundTripperFunc func(request *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(request *http.Request) (*http.Response, error) {
+	return f(request)
+}
+
+func okTransport() http.RoundTripper {
+	return roundTripperFunc(func(request *http.Request) (*http.Response, error) {
+		return Status(http.StatusOK, "application/json", `{"orderRef":"131daac9-16c6-4618-beb0-365768f37288"}`)(
+			request, nil)
+	})
+}
+
+func newRequest(t *testing.T, uri string) *http.Request {
+	t.Helper()
+
+	request, err := http.NewRequestWithContext(context.Background(), http.MethodPost,
+		"https://appapi2.test.bankid.com/rp/v6.0/"+uri, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return request
+}
+
+func TestTransportInjectsFaultForEndpoint(t *testing.T) {
+	transport := NewTransport(okTransport(), WithRule(Rule{Endpoint: "collect", Fault: Maintenance()}))
+
+	response, err := transport.RoundTrip(newRequest(t, "collect"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusServiceUnavailable, response.StatusCode)
+	assert.Equal(t, "application/json", response.Header.Get("Content-Type"))
+
+	response, err = transport.RoundTrip(newRequest(t, "auth"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, 1, transport.Injected())
+}
+
+func TestTransportSkipAndTimes(t *testing.T) {
+	transport := NewTransport(okTransport(), WithRule(Rule{Skip: 1, Times: 2, Fault: ConnectionReset()}))
+
+	var failures []bool
+
+	for range 5 {
+		_, err := transport.RoundTrip(newRequest(t, "collect"))
+		failures = append(failures, errors.Is(err, syscall.ECONNRESET))
+	}
+
+	assert.Equal(t, []bool{false, true, true, false, false}, failures)
+}
+
+func TestTransportProbabilityIsReproducibleWithSeed(t *testing.T) {
+	run := func() int {
+		transport := NewTransport(okTransport(), WithSeed(42),
+			WithRule(Rule{Probability: 0.5, Fault: NonJSON(http.StatusForbidden)}))
+
+		for range 100 {
+			_, _ = transport.RoundTrip(newRequest(t, "auth"))
+		}
+
+		return transport.Injected()
+	}
+
+	injected := run()
+
+	assert.Equal(t, injected, run())
+	assert.Greater(t, injected, 20)
+	assert.Less(t, injected, 80)
+}
+
+func TestTruncate(t *testing.T) {
+	transport := NewTransport(okTransport(), WithRule(Rule{Fault: Truncate(10)}))
+
+	response, err := transport.RoundTrip(newRequest(t, "auth"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := io.ReadAll(response.Body)
+	assert.Equal(t, `{"orderRef`, string(body))
+}
+
+func TestLatencyRespectsContext(t *testing.T) {
+	transport := NewTransport(okTransport(), WithRule(Rule{Fault: Latency(time.Minute)}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	request := newRequest(t, "auth").WithContext(ctx)
+
+	_, err := transport.RoundTrip(request)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.False(t, strings.Contains(err.Error(), "orderRef"))
+}
@@ -0,0 +1,344 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/e-identification/bankid-go/pkg/payload"
+)
+
+// defaultGuardTTL is the age after which an active order is considered expired by the BankID RP API.
+const defaultGuardTTL = 3 * time.Minute
+
+// GuardPolicy decides what the UserGuard does when a user with an active order starts a new order.
+type GuardPolicy string
+
+const (
+	// GuardCancelPrevious cancels the active order of the user before the new order is started.
+	GuardCancelPrevious = GuardPolicy("cancelPrevious")
+	// GuardReturnExisting returns the active order of the user instead of starting a new order, if the orders are of
+	// the same type, the order was started in the guard session of the context, see WithGuardSession, and a collect
+	// finds it pending and not expired. Otherwise, the active order is cancelled.
+	GuardReturnExisting = GuardPolicy("returnExisting")
+)
+
+// guardSessionContextKey is the context key of the guard session.
+type guardSessionContextKey struct{}
+
+// WithGuardSession returns a copy of the context holding the session of the caller, such as the ID of its web
+// session. The UserGuard only returns an active order to the session that started it.
+func WithGuardSession(ctx context.Context, session string) context.Context {
+	return context.WithValue(ctx, guardSessionContextKey{}, session)
+}
+
+// GuardSessionFromContext returns the guard session held by the context.
+func GuardSessionFromContext(ctx context.Context) (string, bool) {
+	session, ok := ctx.Value(guardSessionContextKey{}).(string)
+
+	return session, ok && session != ""
+}
+
+// ActiveOrderStore stores the active order of each user keyed by personal number, see UserGuard.
+type ActiveOrderStore interface {
+	// Get returns the active order of the user. It returns ErrOrderNotFound if the user has no active order.
+	Get(ctx context.Context, personalNumber string) (*Order, error)
+	// Put stores the order as the active order of the user, replacing the previous order.
+	Put(ctx context.Context, personalNumber string, order *Order) error
+	// Delete removes the active order of the user if it is the order with the orderRef.
+	Delete(ctx context.Context, personalNumber string, orderRef string) error
+}
+
+// To ensure that MemoryActiveOrderStore implements the ActiveOrderStore interface.
+var _ ActiveOrderStore = (*MemoryActiveOrderStore)(nil)
+
+// MemoryActiveOrderStore is an ActiveOrderStore holding the orders in memory, suitable for a single instance and for
+// tests.
+type MemoryActiveOrderStore struct {
+	mutex  sync.RWMutex
+	orders map[string]Order
+}
+
+// NewMemoryActiveOrderStore returns a new instance of 'MemoryActiveOrderStore'.
+func NewMemoryActiveOrderStore() *MemoryActiveOrderStore {
+	return &MemoryActiveOrderStore{orders: map[string]Order{}}
+}
+
+// Get returns the active order of the user.
+func (m *MemoryActiveOrderStore) Get(_ context.Context, personalNumber string) (*Order, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	order, ok := m.orders[personalNumber]
+	if !ok {
+		return nil, ErrOrderNotFound
+	}
+
+	return &order, nil
+}
+
+// Put stores the order as the active order of the user.
+func (m *MemoryActiveOrderStore) Put(_ context.Context, personalNumber string, order *Order) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.orders[personalNumber] = *order
+
+	return nil
+}
+
+// Delete removes the active order of the user if it is the order with the orderRef.
+func (m *MemoryActiveOrderStore) Delete(_ context.Context, personalNumber string, orderRef string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if order, ok := m.orders[personalNumber]; ok && order.OrderRef == orderRef {
+		delete(m.orders, personalNumber)
+	}
+
+	return nil
+}
+
+// GuardOption definition.
+type GuardOption func(*UserGuard)
+
+// WithGuardPolicy Function to create GuardOption func to set the policy, defaults to GuardCancelPrevious.
+func WithGuardPolicy(policy GuardPolicy) GuardOption {
+	return func(subject *UserGuard) {
+		subject.policy = policy
+	}
+}
+
+// WithActiveOrderStore Function to create GuardOption func to set the store of the active orders, defaults to a
+// MemoryActiveOrderStore. Use a store shared between the instances of a service to guard users across the instances.
+func WithActiveOrderStore(store ActiveOrderStore) GuardOption {
+	return func(subject *UserGuard) {
+		subject.store = store
+	}
+}
+
+// WithGuardTTL Function to create GuardOption func to set the age after which an active order is considered expired,
+// defaults to three minutes.
+func WithGuardTTL(ttl time.Duration) GuardOption {
+	return func(subject *UserGuard) {
+		subject.ttl = ttl
+	}
+}
+
+// UserGuard starts orders tracking the active order of each user, avoiding alreadyInProgress errors.
+//
+// An order that is started for a personal number fails with alreadyInProgress if the user already has an active
+// order, and the BankID RP API aborts both orders. The guard handles a user that has an active order according to
+// its policy, by either cancelling the active order before the new order is started or returning the active order.
+// The active order is only returned to the guard session that started it, as anyone holding the orderRef can collect
+// the completion data of the order. Orders started without a personal number are not guarded.
+//
+// Release the order of the user once it is complete or failed, an order that is not released is considered active
+// until the TTL has passed.
+type UserGuard struct {
+	client *BankIDClient
+	store  ActiveOrderStore
+	policy GuardPolicy
+	ttl    time.Duration
+
+	// Serializes the orders of each user
+	users keyedMutex
+}
+
+// NewUserGuard returns a new instance of 'UserGuard' starting the orders using the client.
+func NewUserGuard(client *BankIDClient, options ...GuardOption) *UserGuard {
+	instance := &UserGuard{
+		client: client,
+		store:  NewMemoryActiveOrderStore(),
+		policy: GuardCancelPrevious,
+		ttl:    defaultGuardTTL,
+	}
+
+	// Apply options if there are any, can overwrite default
+	for _, option := range options {
+		option(instance)
+	}
+
+	return instance
+}
+
+// Authenticate initiates an authentication order, see BankIDClient.Authenticate, guarding the personal number of
+// the requirement.
+func (g *UserGuard) Authenticate(ctx context.Context, requestPayload *payload.AuthenticationPayload) (*Order, error) {
+	var personalNumber string
+	if requestPayload.Requirement != nil {
+		personalNumber = requestPayload.Requirement.PersonalNumber
+	}
+
+	return g.start(ctx, personalNumber, OrderTypeAuthenticate, func(ctx context.Context) (*Order, error) {
+		started, err := g.client.Authenticate(ctx, requestPayload)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewAuthenticateOrder(started), nil
+	})
+}
+
+// PhoneAuthenticate initiates a phone authentication order, see BankIDClient.PhoneAuthenticate, guarding the
+// personal number.
+func (g *UserGuard) PhoneAuthenticate(
+	ctx context.Context,
+	requestPayload *payload.PhoneAuthenticationPayload,
+) (*Order, error) {
+	return g.start(ctx, requestPayload.PersonalNumber, OrderTypePhoneAuthenticate,
+		func(ctx context.Context) (*Order, error) {
+			started, err := g.client.PhoneAuthenticate(ctx, requestPayload)
+			if err != nil {
+				return nil, err
+			}
+
+			return NewPhoneAuthenticateOrder(started), nil
+		})
+}
+
+// Sign initiates a sign order, see BankIDClient.Sign, guarding the personal number of the requirement.
+func (g *UserGuard) Sign(ctx context.Context, requestPayload *payload.SignPayload) (*Order, error) {
+	var personalNumber string
+	if requestPayload.Requirement != nil {
+		personalNumber = requestPayload.Requirement.PersonalNumber
+	}
+
+	return g.start(ctx, personalNumber, OrderTypeSign, func(ctx context.Context) (*Order, error) {
+		started, err := g.client.Sign(ctx, requestPayload)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewSignOrder(started), nil
+	})
+}
+
+// PhoneSign initiates a phone sign order, see BankIDClient.PhoneSign, guarding the personal number.
+func (g *UserGuard) PhoneSign(ctx context.Context, requestPayload *payload.PhoneSignPayload) (*Order, error) {
+	return g.start(ctx, requestPayload.PersonalNumber, OrderTypePhoneSign, func(ctx context.Context) (*Order, error) {
+		started, err := g.client.PhoneSign(ctx, requestPayload)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewPhoneSignOrder(started), nil
+	})
+}
+
+// Release releases the active order of the user, typically once the order is complete or failed.
+func (g *UserGuard) Release(ctx context.Context, personalNumber string, orderRef string) error {
+	if err := g.store.Delete(ctx, personalNumber, orderRef); err != nil {
+		return fmt.Errorf("unable to release the active order. %w", err)
+	}
+
+	return nil
+}
+
+// start starts an order for the user according to the policy.
+func (g *UserGuard) start(
+	ctx context.Context,
+	personalNumber string,
+	orderType OrderType,
+	start func(context.Context) (*Order, error),
+) (*Order, error) {
+	if personalNumber == "" {
+		return start(ctx)
+	}
+
+	unlock := g.users.lock(personalNumber)
+	defer unlock()
+
+	previous, err := g.active(ctx, personalNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	if previous != nil && g.policy == GuardReturnExisting && previous.Type == orderType && startedIn(ctx, previous) {
+		reused, err := g.reuse(ctx, personalNumber, previous)
+		if err != nil {
+			return nil, err
+		}
+
+		if reused {
+			return previous, nil
+		}
+	}
+
+	if previous != nil {
+		// An order that is no longer pending has nothing left to cancel
+		if previous.IsPending() {
+			if err := g.client.cancelOrphan(ctx, previous.OrderRef); err != nil {
+				return nil, fmt.Errorf("unable to cancel the active order %s. %w", previous.OrderRef, err)
+			}
+		}
+
+		if err := g.store.Delete(ctx, personalNumber, previous.OrderRef); err != nil {
+			return nil, fmt.Errorf("unable to release the active order. %w", err)
+		}
+	}
+
+	order, err := start(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	order.GuardSession, _ = GuardSessionFromContext(ctx)
+
+	if err := g.store.Put(ctx, personalNumber, order); err != nil {
+		// The order cannot be guarded, it is cancelled rather than left behind to block the next order of the user
+		_ = g.client.cancelOrphan(ctx, order.OrderRef)
+
+		return nil, fmt.Errorf("unable to store the active order. %w", err)
+	}
+
+	return order, nil
+}
+
+// reuse collects the active order of the user, updating it with the collected status, and returns true if it is still
+// pending and not expired, in which case it is stored with the collected status.
+func (g *UserGuard) reuse(ctx context.Context, personalNumber string, order *Order) (bool, error) {
+	collected, err := g.client.Collect(ctx, &payload.CollectPayload{OrderRef: order.OrderRef})
+	if err != nil {
+		return false, fmt.Errorf("unable to collect the active order %s. %w", order.OrderRef, err)
+	}
+
+	order.Status, order.HintCode, order.UpdatedAt = collected.Status, string(collected.HintCode), time.Now()
+
+	if !order.IsPending() || !time.Now().Before(order.ExpiresAt()) {
+		return false, nil
+	}
+
+	if err := g.store.Put(ctx, personalNumber, order); err != nil {
+		return false, fmt.Errorf("unable to store the active order. %w", err)
+	}
+
+	return true, nil
+}
+
+// startedIn returns true if the order was started in the guard session of the context.
+func startedIn(ctx context.Context, order *Order) bool {
+	session, ok := GuardSessionFromContext(ctx)
+
+	return ok && session == order.GuardSession
+}
+
+// active returns the active order of the user, nil if there is none or it has expired.
+func (g *UserGuard) active(ctx context.Context, personalNumber string) (*Order, error) {
+	order, err := g.store.Get(ctx, personalNumber)
+	if errors.Is(err, ErrOrderNotFound) {
+		return nil, nil // nolint:nilnil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to get the active order. %w", err)
+	}
+
+	if !order.IsPending() || time.Since(order.StartedAt) > g.ttl {
+		return nil, nil // nolint:nilnil
+	}
+
+	return order, nil
+}
@@ -0,0 +1,228 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/e-identification/bankid-go/pkg/payload"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserGuardCancelsPreviousOrder(t *testing.T) {
+	cancelled := make(chan string, 2)
+
+	bankID, teardown := testBankID(guardHandler(t, cancelled))
+	defer teardown()
+
+	guard := NewUserGuard(bankID)
+	ctx := context.Background()
+
+	first, err := guard.Authenticate(ctx, guardedAuthenticationPayload("199001011234"))
+	assert.NoError(t, err)
+
+	second, err := guard.Authenticate(ctx, guardedAuthenticationPayload("199001011234"))
+	assert.NoError(t, err)
+	assert.NotEqual(t, first.OrderRef, second.OrderRef)
+	assert.Equal(t, first.OrderRef, receive(t, cancelled))
+
+	// Another user is not affected
+	_, err = guard.Authenticate(ctx, guardedAuthenticationPayload("199001015678"))
+	assert.NoError(t, err)
+
+	// A released order is not cancelled
+	assert.NoError(t, guard.Release(ctx, "199001011234", second.OrderRef))
+
+	_, err = guard.Authenticate(ctx, guardedAuthenticationPayload("199001011234"))
+	assert.NoError(t, err)
+	assert.Empty(t, cancelled)
+}
+
+func TestUserGuardReturnsExistingOrder(t *testing.T) {
+	cancelled := make(chan string, 2)
+
+	bankID, teardown := testBankID(guardHandler(t, cancelled))
+	defer teardown()
+
+	guard := NewUserGuard(bankID, WithGuardPolicy(GuardReturnExisting))
+	ctx := WithGuardSession(context.Background(), "session")
+
+	first, err := guard.Authenticate(ctx, guardedAuthenticationPayload("199001011234"))
+	assert.NoError(t, err)
+	assert.Equal(t, "session", first.GuardSession)
+
+	second, err := guard.Authenticate(ctx, guardedAuthenticationPayload("199001011234"))
+	assert.NoError(t, err)
+	assert.Equal(t, first.OrderRef, second.OrderRef)
+	assert.Equal(t, "outstandingTransaction", second.HintCode)
+	assert.Empty(t, cancelled)
+
+	// The order is not returned to another session, nor to a caller without a session
+	third, err := guard.Authenticate(WithGuardSession(context.Background(), "other"),
+		guardedAuthenticationPayload("199001011234"))
+	assert.NoError(t, err)
+	assert.NotEqual(t, first.OrderRef, third.OrderRef)
+	assert.Equal(t, first.OrderRef, receive(t, cancelled))
+
+	fourth, err := guard.Authenticate(context.Background(), guardedAuthenticationPayload("199001011234"))
+	assert.NoError(t, err)
+	assert.NotEqual(t, third.OrderRef, fourth.OrderRef)
+	assert.Equal(t, third.OrderRef, receive(t, cancelled))
+}
+
+func TestUserGuardReturnsOnlyPendingOrders(t *testing.T) {
+	cancelled := make(chan string, 1)
+
+	var status atomic.Value
+
+	status.Store(`"status":"complete"`)
+
+	handler := guardHandler(t, cancelled)
+
+	bankID, teardown := testBankID(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path == "/rp/v6.0/collect" {
+			stringToResponseHandler(t, fmt.Sprintf(`{"orderRef":"order-1",%s}`, status.Load()))(writer, request)
+
+			return
+		}
+
+		handler(writer, request)
+	})
+	defer teardown()
+
+	guard := NewUserGuard(bankID, WithGuardPolicy(GuardReturnExisting))
+	ctx := WithGuardSession(context.Background(), "session")
+
+	first, err := guard.Authenticate(ctx, guardedAuthenticationPayload("199001011234"))
+	assert.NoError(t, err)
+
+	// The complete order is not returned, and has nothing left to cancel
+	second, err := guard.Authenticate(ctx, guardedAuthenticationPayload("199001011234"))
+	assert.NoError(t, err)
+	assert.NotEqual(t, first.OrderRef, second.OrderRef)
+	assert.Empty(t, cancelled)
+
+	// The QR code of the pending order has expired without the order being started
+	active, _ := guard.store.Get(ctx, "199001011234")
+	active.QrStartToken, active.QrStartSecret = "token", "secret"
+	active.StartedAt = time.Now().Add(-QRCodeLifetime)
+	_ = guard.store.Put(ctx, "199001011234", active)
+
+	status.Store(`"status":"pending","hintCode":"outstandingTransaction"`)
+
+	third, err := guard.Authenticate(ctx, guardedAuthenticationPayload("199001011234"))
+	assert.NoError(t, err)
+	assert.NotEqual(t, second.OrderRef, third.OrderRef)
+	assert.Equal(t, second.OrderRef, receive(t, cancelled))
+}
+
+func TestUserGuardReplacesOrderOfAnotherType(t *testing.T) {
+	cancelled := make(chan string, 1)
+
+	bankID, teardown := testBankID(guardHandler(t, cancelled))
+	defer teardown()
+
+	guard := NewUserGuard(bankID, WithGuardPolicy(GuardReturnExisting))
+	ctx := WithGuardSession(context.Background(), "session")
+
+	first, err := guard.Authenticate(ctx, guardedAuthenticationPayload("199001011234"))
+	assert.NoError(t, err)
+
+	_, err = guard.PhoneAuthenticate(ctx, &payload.PhoneAuthenticationPayload{
+		PersonalNumber: "199001011234", CallInitiator: "RP",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, first.OrderRef, receive(t, cancelled))
+}
+
+func TestUserGuardIgnoresExpiredOrders(t *testing.T) {
+	cancelled := make(chan string, 1)
+
+	bankID, teardown := testBankID(guardHandler(t, cancelled))
+	defer teardown()
+
+	guard := NewUserGuard(bankID, WithGuardTTL(time.Millisecond))
+	ctx := context.Background()
+
+	_, err := guard.Authenticate(ctx, guardedAuthenticationPayload("199001011234"))
+	assert.NoError(t, err)
+
+	time.Sleep(2 * time.Millisecond)
+
+	_, err = guard.Authenticate(ctx, guardedAuthenticationPayload("199001011234"))
+	assert.NoError(t, err)
+	assert.Empty(t, cancelled)
+}
+
+func TestUserGuardSerializesOrdersOfUser(t *testing.T) {
+	var started atomic.Int32
+
+	bankID, teardown := testBankID(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case "/rp/v6.0/auth":
+			started.Add(1)
+			stringToResponseHandler(t, `{"orderRef":"order"}`)(writer, request)
+		default:
+			stringToResponseHandler(t, `{"orderRef":"order","status":"pending","hintCode":"started"}`)(writer, request)
+		}
+	})
+	defer teardown()
+
+	guard := NewUserGuard(bankID, WithGuardPolicy(GuardReturnExisting))
+
+	var waitGroup sync.WaitGroup
+
+	for range 10 {
+		waitGroup.Add(1)
+
+		go func() {
+			defer waitGroup.Done()
+
+			_, err := guard.Authenticate(WithGuardSession(context.Background(), "session"),
+				guardedAuthenticationPayload("199001011234"))
+			assert.NoError(t, err)
+		}()
+	}
+
+	waitGroup.Wait()
+
+	assert.Equal(t, int32(1), started.Load())
+	assert.Empty(t, guard.users.locks)
+}
+
+// guardHandler starts orders with unique orderRefs, answers each collect with a pending order and sends the orderRef
+// of each cancelled order to the channel.
+func guardHandler(t *testing.T, cancelled chan<- string) http.HandlerFunc {
+	t.Helper()
+
+	var orders atomic.Int32
+
+	return func(writer http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case "/rp/v6.0/auth", "/rp/v6.0/phone/auth":
+			body := fmt.Sprintf(`{"orderRef":"order-%d"}`, orders.Add(1))
+			stringToResponseHandler(t, body)(writer, request)
+		case "/rp/v6.0/collect":
+			var collectPayload payload.CollectPayload
+			assert.NoError(t, json.NewDecoder(request.Body).Decode(&collectPayload))
+
+			body := fmt.Sprintf(`{"orderRef":%q,"status":"pending","hintCode":"outstandingTransaction"}`,
+				collectPayload.OrderRef)
+			stringToResponseHandler(t, body)(writer, request)
+		default:
+			cancelRecordingHandler(t, cancelled)(writer, request)
+		}
+	}
+}
+
+func guardedAuthenticationPayload(personalNumber string) *payload.AuthenticationPayload {
+	return &payload.AuthenticationPayload{
+		EndUserIP: "192.168.1.1", Requirement: &payload.Requirement{PersonalNumber: personalNumber},
+	}
+}
@@ -0,0 +1,454 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/e-identification/bankid-go/pkg/internal/http"
+	"github.com/e-identification/bankid-go/pkg/payload"
+	"github.com/e-identification/bankid-go/pkg/response"
+)
+
+// abandonCancelTimeout is the timeout of the cancel of an abandoned order.
+const abandonCancelTimeout = 10 * time.Second
+
+// HandleOption definition.
+type HandleOption func(*OrderHandle)
+
+// WithInactivityTimeout Function to create HandleOption func to cancel the order when the handle has not been touched
+// for the duration, see OrderHandle.Touch.
+func WithInactivityTimeout(timeout time.Duration) HandleOption {
+	return func(subject *OrderHandle) {
+		subject.inactivityTimeout = timeout
+	}
+}
+
+// WithExpiresAt Function to create HandleOption func to set the time the order is expected to expire, defaults to
+// OrderLifetime from the creation of the handle. See BankIDClient.TrackOrder for a handle whose expiry follows the
+// order.
+func WithExpiresAt(expiresAt time.Time) HandleOption {
+	return func(subject *OrderHandle) {
+		subject.expiresAt = expiresAt
+	}
+}
+
+// WithOnAbandon Function to create HandleOption func to invoke the hook after an abandoned order has been cancelled,
+// with the error of the cancel if any.
+func WithOnAbandon(hook func(orderRef string, err error)) HandleOption {
+	return func(subject *OrderHandle) {
+		subject.onAbandon = hook
+	}
+}
+
+// OrderHandle is an outstanding order that is cancelled when it is abandoned.
+//
+// The order is abandoned when the context of the handle is done, such as the context of the HTTP request of the user,
+// or when the handle has not been touched within the inactivity timeout. The handle is finished when the order is
+// cancelled or released, release the handle once the order is complete or failed. The handle is released when the
+// order is expected to have expired, as there is no order left to cancel.
+type OrderHandle struct {
+	orderRef          string
+	canceller         OrderCanceller
+	inactivityTimeout time.Duration
+	onAbandon         func(orderRef string, err error)
+	onFinish          func(*OrderHandle)
+
+	// The order whose expiry the handle follows, nil unless tracked with TrackOrder
+	order     *Order
+	mutex     sync.Mutex
+	expiresAt time.Time
+	// Signals the watch that the expiry has changed
+	expiry chan struct{}
+
+	activity chan struct{}
+	done     chan struct{}
+	once     sync.Once
+	err      error
+}
+
+// NewOrderHandle returns a new instance of 'OrderHandle' cancelling the order using the canceller when the order is
+// abandoned. See BankIDClient.Track for a handle of an order started by the client.
+func NewOrderHandle(
+	ctx context.Context,
+	canceller OrderCanceller,
+	orderRef string,
+	options ...HandleOption,
+) *OrderHandle {
+	return newOrderHandle(ctx, canceller, orderRef, nil, options...)
+}
+
+func newOrderHandle(
+	ctx context.Context,
+	canceller OrderCanceller,
+	orderRef string,
+	tracker *orderTracker,
+	options ...HandleOption,
+) *OrderHandle {
+	instance := &OrderHandle{
+		orderRef:  orderRef,
+		canceller: canceller,
+		expiresAt: time.Now().Add(OrderLifetime),
+		expiry:    make(chan struct{}, 1),
+		activity:  make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+
+	// Apply options if there are any, can overwrite default
+	for _, option := range options {
+		option(instance)
+	}
+
+	// The handle is tracked before it is watched, so it cannot finish before it is tracked
+	if tracker != nil {
+		tracker.track(instance)
+		instance.onFinish = tracker.finish
+	}
+
+	go instance.watch(ctx)
+
+	return instance
+}
+
+// OrderRef returns the reference of the order.
+func (h *OrderHandle) OrderRef() string {
+	return h.orderRef
+}
+
+// ExpiresAt returns the time the order is expected to expire.
+func (h *OrderHandle) ExpiresAt() time.Time {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.expiresAt
+}
+
+// Remaining returns the time left until the order is expected to expire, zero once it has expired.
+func (h *OrderHandle) Remaining() time.Duration {
+	return max(time.Until(h.ExpiresAt()), 0)
+}
+
+// Touch records activity on the order, which postpones the inactivity timeout.
+func (h *OrderHandle) Touch() {
+	select {
+	case h.activity <- struct{}{}:
+	default:
+	}
+}
+
+// Cancel cancels the order and finishes the handle. Only the first call of Cancel or Release has an effect, the
+// error of the cancel is returned to every call of Cancel.
+func (h *OrderHandle) Cancel(ctx context.Context) error {
+	h.finish(func() error {
+		if _, err := h.canceller.Cancel(ctx, &payload.CancelPayload{OrderRef: h.orderRef}); err != nil {
+			return fmt.Errorf("unable to cancel order %s. %w", h.orderRef, err)
+		}
+
+		return nil
+	})
+
+	return h.err
+}
+
+// Release finishes the handle without cancelling the order.
+func (h *OrderHandle) Release() {
+	h.finish(func() error { return nil })
+}
+
+// Done returns a channel that is closed when the handle is finished.
+func (h *OrderHandle) Done() <-chan struct{} {
+	return h.done
+}
+
+// Err returns the error of the cancel of the order, it is nil until the handle is finished.
+func (h *OrderHandle) Err() error {
+	select {
+	case <-h.done:
+		return h.err
+	default:
+		return nil
+	}
+}
+
+// watch cancels the order when it is abandoned.
+func (h *OrderHandle) watch(ctx context.Context) {
+	// A nil channel never fires, which disables the inactivity timeout
+	var (
+		timer      *time.Timer
+		inactivity <-chan time.Time
+	)
+
+	if h.inactivityTimeout > 0 {
+		timer = time.NewTimer(h.inactivityTimeout)
+		defer timer.Stop()
+
+		inactivity = timer.C
+	}
+
+	expiry := time.NewTimer(time.Until(h.ExpiresAt()))
+	defer expiry.Stop()
+
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-expiry.C:
+			h.Release()
+
+			return
+		case <-h.expiry:
+			expiry.Reset(time.Until(h.ExpiresAt()))
+		case <-ctx.Done():
+			h.abandon()
+
+			return
+		case <-inactivity:
+			h.abandon()
+
+			return
+		case <-h.activity:
+			if timer != nil {
+				timer.Reset(h.inactivityTimeout)
+			}
+		}
+	}
+}
+
+// observe updates the expiry of the order from a collect response of the order.
+func (h *OrderHandle) observe(collected *response.CollectResponse) {
+	if h.order == nil {
+		return
+	}
+
+	h.mutex.Lock()
+	h.order.Status, h.order.HintCode = collected.Status, collected.HintCode
+	h.expiresAt = h.order.ExpiresAt()
+	h.mutex.Unlock()
+
+	select {
+	case h.expiry <- struct{}{}:
+	default:
+	}
+}
+
+// abandon cancels the abandoned order, using a context of its own as the context of the handle may be done.
+func (h *OrderHandle) abandon() {
+	ctx, cancel := context.WithTimeout(context.Background(), abandonCancelTimeout)
+	defer cancel()
+
+	err := h.Cancel(ctx)
+
+	if h.onAbandon != nil {
+		h.onAbandon(h.orderRef, err)
+	}
+}
+
+func (h *OrderHandle) finish(function func() error) {
+	h.once.Do(func() {
+		h.err = function()
+
+		if h.onFinish != nil {
+			h.onFinish(h)
+		}
+
+		close(h.done)
+	})
+}
+
+// orderTracker tracks the outstanding orders started by a client and its calls in flight, allowing a graceful
+// shutdown of the client. The orders are tracked with the time they were started and forgotten once they are expected
+// to have expired, as orders may never be collected by the client.
+type orderTracker struct {
+	mutex    sync.Mutex
+	pending  map[string]time.Time
+	handles  map[string]*OrderHandle
+	calls    int
+	idle     chan struct{}
+	shutdown bool
+}
+
+func newOrderTracker() *orderTracker {
+	idle := make(chan struct{})
+	close(idle)
+
+	return &orderTracker{pending: map[string]time.Time{}, handles: map[string]*OrderHandle{}, idle: idle}
+}
+
+// begin registers a call in flight, calls that start an order are refused once the client is shut down.
+func (t *orderTracker) begin(request *http.Request) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.shutdown && startsOrder(request.URI) {
+		return ErrClientShutdown
+	}
+
+	t.prune(time.Now())
+
+	if t.calls == 0 {
+		t.idle = make(chan struct{})
+	}
+
+	t.calls++
+
+	return nil
+}
+
+// end unregisters a call in flight and records the orders that were started or finished by it.
+func (t *orderTracker) end(request *http.Request, httpResponse http.Response) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+
+	switch typed := httpResponse.(type) {
+	case *response.AuthenticateResponse:
+		t.pending[typed.OrderRef] = now
+	case *response.PhoneAuthenticateResponse:
+		t.pending[typed.OrderRef] = now
+	case *response.SignResponse:
+		t.pending[typed.OrderRef] = now
+	case *response.PhoneSignResponse:
+		t.pending[typed.OrderRef] = now
+	case *response.CollectResponse:
+		if !typed.IsPending() {
+			delete(t.pending, typed.OrderRef)
+		}
+
+		if handle, ok := t.handles[typed.OrderRef]; ok {
+			handle.observe(typed)
+		}
+	case *response.CancelResponse:
+		if cancelPayload, ok := request.Payload.(*payload.CancelPayload); ok {
+			delete(t.pending, cancelPayload.OrderRef)
+		}
+	}
+
+	t.calls--
+
+	if t.calls == 0 {
+		close(t.idle)
+	}
+}
+
+// prune forgets the orders that are expected to have expired, the tracker is expected to be locked.
+func (t *orderTracker) prune(now time.Time) {
+	for orderRef, startedAt := range t.pending {
+		if now.Sub(startedAt) >= OrderLifetime {
+			delete(t.pending, orderRef)
+		}
+	}
+}
+
+// track registers the handle of an order.
+func (t *orderTracker) track(handle *OrderHandle) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.handles[handle.orderRef] = handle
+}
+
+// finish forgets the order of a finished handle, which is either cancelled or released.
+func (t *orderTracker) finish(handle *OrderHandle) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.handles[handle.orderRef] == handle {
+		delete(t.handles, handle.orderRef)
+	}
+
+	delete(t.pending, handle.orderRef)
+}
+
+// Track returns a handle of an order started by the client, which cancels the order when it is abandoned. See
+// OrderHandle.
+func (b BankIDClient) Track(ctx context.Context, orderRef string, options ...HandleOption) *OrderHandle {
+	return newOrderHandle(ctx, b, orderRef, b.orders, options...)
+}
+
+// TrackOrder returns a handle of an order started by the client, see Track, expiring with the order. The expiry
+// follows the collects of the order made by the client, see Order.ExpiresAt, such as when the user scans the QR code.
+func (b BankIDClient) TrackOrder(ctx context.Context, order *Order, options ...HandleOption) *OrderHandle {
+	tracked := *order
+
+	return b.Track(ctx, order.OrderRef, append([]HandleOption{
+		WithExpiresAt(order.ExpiresAt()), withOrder(&tracked),
+	}, options...)...)
+}
+
+// withOrder Function to create HandleOption func to follow the expiry of the order.
+func withOrder(order *Order) HandleOption {
+	return func(subject *OrderHandle) {
+		subject.order = order
+	}
+}
+
+// Shutdown shuts the client down gracefully.
+//
+// Orders started after the call are refused with ErrClientShutdown. The calls in flight are waited for, then the
+// outstanding orders started by the client are cancelled, which are the orders that have been neither collected as
+// complete or failed, cancelled, released by their handle nor expired. It returns the errors of the cancels joined together,
+// or the error of the context if it is done first.
+func (b BankIDClient) Shutdown(ctx context.Context) error {
+	if b.orders == nil {
+		return nil
+	}
+
+	b.orders.mutex.Lock()
+	b.orders.shutdown = true
+	idle := b.orders.idle
+	b.orders.mutex.Unlock()
+
+	select {
+	case <-idle:
+	case <-ctx.Done():
+		return fmt.Errorf("unable to wait for the calls in flight. %w", ctx.Err())
+	}
+
+	b.orders.mutex.Lock()
+
+	// The orders that have expired are not cancelled
+	b.orders.prune(time.Now())
+
+	cancels := make([]func(context.Context) error, 0, len(b.orders.pending)+len(b.orders.handles))
+	for _, handle := range b.orders.handles {
+		cancels = append(cancels, handle.Cancel)
+	}
+
+	for orderRef := range b.orders.pending {
+		if _, ok := b.orders.handles[orderRef]; ok {
+			continue
+		}
+
+		cancels = append(cancels, func(ctx context.Context) error {
+			if err := b.cancelOrphan(ctx, orderRef); err != nil {
+				return fmt.Errorf("unable to cancel order %s. %w", orderRef, err)
+			}
+
+			return nil
+		})
+	}
+
+	b.orders.mutex.Unlock()
+
+	var (
+		waitGroup sync.WaitGroup
+		errs      = make([]error, len(cancels))
+	)
+
+	for i, cancel := range cancels {
+		waitGroup.Add(1)
+
+		go func() {
+			defer waitGroup.Done()
+
+			errs[i] = cancel(ctx)
+		}()
+	}
+
+	waitGroup.Wait()
+
+	return errors.Join(errs...)
+}
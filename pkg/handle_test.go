@@ -0,0 +1,230 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/e-identification/bankid-go/pkg/payload"
+	"github.com/e-identification/bankid-go/pkg/response"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrderHandleCancelsWhenContextIsCancelled(t *testing.T) {
+	cancelled := make(chan string, 1)
+
+	bankID, teardown := testBankID(cancelRecordingHandler(t, cancelled))
+	defer teardown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var abandoned atomic.Value
+
+	handle := bankID.Track(ctx, "order", WithOnAbandon(func(orderRef string, err error) {
+		assert.NoError(t, err)
+		abandoned.Store(orderRef)
+	}))
+
+	cancel()
+
+	assert.Equal(t, "order", receive(t, cancelled))
+	<-handle.Done()
+	assert.NoError(t, handle.Err())
+	assert.Eventually(t, func() bool { return abandoned.Load() == "order" }, time.Second, time.Millisecond)
+}
+
+func TestOrderHandleCancelsWhenInactive(t *testing.T) {
+	cancelled := make(chan string, 1)
+
+	bankID, teardown := testBankID(cancelRecordingHandler(t, cancelled))
+	defer teardown()
+
+	handle := bankID.Track(context.Background(), "order", WithInactivityTimeout(50*time.Millisecond))
+
+	// Touching the handle postpones the timeout
+	for range 5 {
+		time.Sleep(20 * time.Millisecond)
+		handle.Touch()
+	}
+
+	select {
+	case <-handle.Done():
+		t.Fatal("handle finished while active")
+	default:
+	}
+
+	assert.Equal(t, "order", receive(t, cancelled))
+	<-handle.Done()
+}
+
+func TestOrderHandleRelease(t *testing.T) {
+	bankID, teardown := testBankID(func(http.ResponseWriter, *http.Request) {
+		t.Error("released order was cancelled")
+	})
+	defer teardown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	handle := bankID.Track(ctx, "order", WithInactivityTimeout(time.Millisecond))
+	handle.Release()
+	cancel()
+
+	<-handle.Done()
+	assert.NoError(t, handle.Cancel(context.Background()))
+	assert.NoError(t, bankID.Shutdown(context.Background()))
+}
+
+func TestOrderHandleExpires(t *testing.T) {
+	bankID, teardown := testBankID(func(http.ResponseWriter, *http.Request) {
+		t.Error("expired order was cancelled")
+	})
+	defer teardown()
+
+	order := NewPhoneAuthenticateOrder(&response.PhoneAuthenticateResponse{OrderRef: "order"})
+	order.StartedAt = time.Now().Add(-OrderLifetime + 50*time.Millisecond)
+
+	handle := bankID.TrackOrder(context.Background(), order)
+
+	assert.Equal(t, order.ExpiresAt(), handle.ExpiresAt())
+	assert.InDelta(t, 50*time.Millisecond, handle.Remaining(), float64(40*time.Millisecond))
+
+	<-handle.Done()
+	assert.Zero(t, handle.Remaining())
+	assert.NoError(t, handle.Err())
+}
+
+func TestOrderHandleFollowsTheExpiryOfTheOrder(t *testing.T) {
+	bankID, teardown := testBankID(stringToResponseHandler(t, `{"orderRef":"order","status":"pending",`+
+		`"hintCode":"userSign"}`))
+	defer teardown()
+
+	order := NewAuthenticateOrder(&response.AuthenticateResponse{
+		OrderRef: "order", QrStartToken: "token", QrStartSecret: "secret", TimeOfResponse: time.Now(),
+	})
+
+	handle := bankID.TrackOrder(context.Background(), order)
+	defer handle.Release()
+
+	// The order expires with its QR code until the user scans it
+	assert.Equal(t, order.QRCodeExpiresAt(), handle.ExpiresAt())
+
+	_, err := bankID.Collect(context.Background(), &payload.CollectPayload{OrderRef: "order"})
+	assert.NoError(t, err)
+
+	assert.Equal(t, order.StartedAt.Add(OrderLifetime), handle.ExpiresAt())
+	assert.Equal(t, "", order.HintCode, "the order of the caller is not modified")
+}
+
+func TestShutdown(t *testing.T) {
+	cancelled := make(chan string, 2)
+
+	var mutex sync.Mutex
+
+	bankID, teardown := testBankID(func(writer http.ResponseWriter, request *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		switch request.URL.Path {
+		case "/rp/v6.0/auth":
+			stringToResponseHandler(t, `{"orderRef":"started"}`)(writer, request)
+		case "/rp/v6.0/collect":
+			stringToResponseHandler(t, `{"orderRef":"collected","status":"complete"}`)(writer, request)
+		default:
+			cancelRecordingHandler(t, cancelled)(writer, request)
+		}
+	})
+	defer teardown()
+
+	ctx := context.Background()
+	requestPayload := &payload.AuthenticationPayload{EndUserIP: "192.168.1.1"}
+
+	_, err := bankID.Authenticate(ctx, requestPayload)
+	assert.NoError(t, err)
+
+	handle := bankID.Track(ctx, "tracked")
+
+	// Orders collected as complete are no longer outstanding, nor are orders that have expired
+	bankID.orders.pending["collected"] = time.Now()
+	bankID.orders.pending["expired"] = time.Now().Add(-OrderLifetime)
+	_, err = bankID.Collect(ctx, &payload.CollectPayload{OrderRef: "collected"})
+	assert.NoError(t, err)
+	assert.NotContains(t, bankID.orders.pending, "expired")
+
+	bankID.orders.pending["expired"] = time.Now().Add(-OrderLifetime)
+
+	assert.NoError(t, bankID.Shutdown(ctx))
+	assert.ElementsMatch(t, []string{"started", "tracked"}, []string{receive(t, cancelled), receive(t, cancelled)})
+	<-handle.Done()
+
+	_, err = bankID.Authenticate(ctx, requestPayload)
+	assert.ErrorIs(t, err, ErrClientShutdown)
+	assert.False(t, IsTemporary(err))
+
+	// Orders that are started are refused, the outstanding orders can still be collected and cancelled
+	_, err = bankID.Collect(ctx, &payload.CollectPayload{OrderRef: "collected"})
+	assert.NoError(t, err)
+}
+
+func TestShutdownWaitsForCallsInFlight(t *testing.T) {
+	release := make(chan struct{})
+
+	bankID, teardown := testBankID(func(writer http.ResponseWriter, request *http.Request) {
+		<-release
+		stringToResponseHandler(t, `{"orderRef":"order","status":"pending"}`)(writer, request)
+	})
+	defer teardown()
+
+	go func() {
+		_, _ = bankID.Collect(context.Background(), &payload.CollectPayload{OrderRef: "order"})
+	}()
+
+	assert.Eventually(t, func() bool {
+		bankID.orders.mutex.Lock()
+		defer bankID.orders.mutex.Unlock()
+
+		return bankID.orders.calls == 1
+	}, time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	assert.ErrorIs(t, bankID.Shutdown(ctx), context.DeadlineExceeded)
+
+	close(release)
+
+	assert.NoError(t, bankID.Shutdown(context.Background()))
+}
+
+// cancelRecordingHandler sends the orderRef of each cancelled order to the channel.
+func cancelRecordingHandler(t *testing.T, cancelled chan<- string) http.HandlerFunc {
+	t.Helper()
+
+	return func(writer http.ResponseWriter, request *http.Request) {
+		assert.Equal(t, "/rp/v6.0/cancel", request.URL.Path)
+
+		var cancelPayload payload.CancelPayload
+		assert.NoError(t, json.NewDecoder(request.Body).Decode(&cancelPayload))
+
+		cancelled <- cancelPayload.OrderRef
+
+		stringToResponseHandler(t, "{}")(writer, request)
+	}
+}
+
+func receive(t *testing.T, channel <-chan string) string {
+	t.Helper()
+
+	select {
+	case value := <-channel:
+		return value
+	case <-time.After(time.Second):
+		t.Fatal("timed out")
+
+		return ""
+	}
+}
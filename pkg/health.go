@@ -0,0 +1,193 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/e-identification/bankid-go/pkg/configuration"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// healthProbeTimeout is the timeout of a probe of the BankID RP API made by a health check.
+const healthProbeTimeout = 10 * time.Second
+
+// healthCircuitThreshold is the number of consecutive failed health checks that opens the circuit.
+const healthCircuitThreshold = 3
+
+// CircuitState is the state of the circuit of the health check, see HealthStatus.
+type CircuitState string
+
+const (
+	// CircuitClosed is the state of a circuit whose latest health check passed, or failed fewer times in a row than
+	// the threshold.
+	CircuitClosed = CircuitState("closed")
+	// CircuitOpen is the state of a circuit whose health checks have failed the threshold number of times in a row.
+	CircuitOpen = CircuitState("open")
+)
+
+// HealthStatus holds the outcome of a health check of the BankID RP API.
+type HealthStatus struct {
+	// True if the BankID RP API is reachable with the client certificate and the certificate is valid.
+	Healthy bool
+	// The error that made the check fail, nil if healthy.
+	Err error
+	// The latency of the probe of the BankID RP API.
+	Latency time.Duration
+	// The time the check was performed.
+	CheckedAt time.Time
+	// The metadata of the client certificate, nil if it could not be loaded.
+	Certificate *configuration.CertificateInfo
+	// The number of consecutive failed checks, zero if healthy.
+	ConsecutiveFailures int
+	// The state of the circuit, open once the checks have failed three times in a row.
+	Circuit CircuitState
+}
+
+// healthCache caches the latest HealthStatus, the probes of concurrent checks are shared.
+type healthCache struct {
+	mutex    sync.Mutex
+	status   *HealthStatus
+	failures int
+	probes   singleflight.Group
+}
+
+// Health reports whether the BankID RP API is reachable with the client certificate.
+//
+// The API is probed by collecting a non-existing order, which the BankID RP API answers with invalidParameters when
+// the RP is authorized. The status is cached for the health cache interval of the configuration, to avoid probing
+// the BankID RP API on every call, and concurrent checks share a single probe.
+//
+// The probe is not cancelled with the context of the check that started it, it fails once it has taken ten seconds.
+// A check whose context is done before the probe completes returns an unhealthy status holding the error of the
+// context, which is neither cached nor counted as a failure.
+func (b BankIDClient) Health(ctx context.Context) HealthStatus {
+	if b.health == nil {
+		return (&healthCache{}).record(b.checkHealth(ctx))
+	}
+
+	b.health.mutex.Lock()
+	cached := b.health.status
+	b.health.mutex.Unlock()
+
+	if cached != nil && time.Since(cached.CheckedAt) < b.configuration.HealthCacheInterval {
+		return *cached
+	}
+
+	probe := b.health.probes.DoChan("health", func() (any, error) {
+		probeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), healthProbeTimeout)
+		defer cancel()
+
+		return b.health.record(b.checkHealth(probeCtx)), nil
+	})
+
+	select {
+	case result := <-probe:
+		return result.Val.(HealthStatus) // nolint:forcetypeassert
+	case <-ctx.Done():
+		return b.health.current(HealthStatus{Err: ctx.Err(), CheckedAt: time.Now()})
+	}
+}
+
+// record counts the consecutive failures, sets the circuit state of the status and caches it.
+func (h *healthCache) record(status HealthStatus) HealthStatus {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if status.Healthy {
+		h.failures = 0
+	} else {
+		h.failures++
+	}
+
+	status.ConsecutiveFailures, status.Circuit = h.failures, h.circuit()
+	h.status = &status
+
+	return status
+}
+
+// current sets the consecutive failures and the circuit state of the status without recording it.
+func (h *healthCache) current(status HealthStatus) HealthStatus {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	status.ConsecutiveFailures, status.Circuit = h.failures, h.circuit()
+
+	return status
+}
+
+// circuit returns the state of the circuit for the consecutive failures.
+func (h *healthCache) circuit() CircuitState {
+	if h.failures >= healthCircuitThreshold {
+		return CircuitOpen
+	}
+
+	return CircuitClosed
+}
+
+// HealthHandler returns a http.Handler reporting the health as JSON, suitable for readiness probes.
+//
+// The handler responds with 200 OK when healthy and 503 Service Unavailable otherwise.
+func (b BankIDClient) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		status := b.Health(request.Context())
+
+		body := struct {
+			Healthy             bool         `json:"healthy"`
+			Error               string       `json:"error,omitempty"`
+			LatencyMs           int64        `json:"latencyMs"`
+			CheckedAt           time.Time    `json:"checkedAt"`
+			Circuit             CircuitState `json:"circuit"`
+			ConsecutiveFailures int          `json:"consecutiveFailures"`
+			CertificateEnd      *time.Time   `json:"certificateNotAfter,omitempty"`
+		}{
+			Healthy: status.Healthy, LatencyMs: status.Latency.Milliseconds(), CheckedAt: status.CheckedAt,
+			Circuit: status.Circuit, ConsecutiveFailures: status.ConsecutiveFailures,
+		}
+
+		if status.Err != nil {
+			body.Error = status.Err.Error()
+		}
+
+		if status.Certificate != nil {
+			body.CertificateEnd = &status.Certificate.NotAfter
+		}
+
+		writer.Header().Set("Content-Type", "application/json")
+
+		if !status.Healthy {
+			writer.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		_ = json.NewEncoder(writer).Encode(body)
+	})
+}
+
+func (b BankIDClient) checkHealth(ctx context.Context) HealthStatus {
+	status := HealthStatus{CheckedAt: time.Now()}
+
+	leaf, err := b.leafCertificate()
+	if err != nil {
+		status.Err = err
+
+		return status
+	}
+
+	status.Certificate = configuration.NewCertificateInfo(leaf)
+
+	if err := status.Certificate.Validate(status.CheckedAt); err != nil {
+		status.Err = err
+
+		return status
+	}
+
+	result := b.probe(ctx)
+	status.Latency = result.latency
+	status.Err = result.err
+	status.Healthy = result.err == nil
+
+	return status
+}
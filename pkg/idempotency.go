@@ -0,0 +1,221 @@
+package pkg
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/e-identification/bankid-go/pkg/payload"
+	"github.com/e-identification/bankid-go/pkg/response"
+)
+
+// defaultIdempotencyTTL is the time an idempotency key maps to the order it started.
+const defaultIdempotencyTTL = 3 * time.Minute
+
+// ErrIdempotencyKeyReused is returned by the IdempotentClient when an idempotency key is reused for another type of
+// order or with another payload.
+var ErrIdempotencyKeyReused = errors.New("idempotency key is used by another order")
+
+// idempotencyKeyContextKey is the context key of the idempotency key.
+type idempotencyKeyContextKey struct{}
+
+// WithIdempotencyKey returns a copy of the context holding the idempotency key used by the IdempotentClient.
+func WithIdempotencyKey(ctx context.Context, idempotencyKey string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyContextKey{}, idempotencyKey)
+}
+
+// IdempotencyKeyFromContext returns the idempotency key held by the context.
+func IdempotencyKeyFromContext(ctx context.Context) (string, bool) {
+	idempotencyKey, ok := ctx.Value(idempotencyKeyContextKey{}).(string)
+
+	return idempotencyKey, ok && idempotencyKey != ""
+}
+
+// IdempotencyOption definition.
+type IdempotencyOption func(*IdempotentClient)
+
+// WithIdempotencyTTL Function to create IdempotencyOption func to set the time an idempotency key maps to the order it
+// started, defaults to three minutes.
+func WithIdempotencyTTL(ttl time.Duration) IdempotencyOption {
+	return func(subject *IdempotentClient) {
+		subject.ttl = ttl
+	}
+}
+
+// To ensure that IdempotentClient implements the BankID interface.
+var _ BankID = (*IdempotentClient)(nil)
+
+// IdempotentClient starts at most one order per idempotency key, see WithIdempotencyKey, which makes retries of the
+// start of an order safe.
+//
+// Authenticate and Sign called with an idempotency key that maps to an order started within the TTL return the
+// response of that order instead of calling the BankID RP API again, provided that they pass the same payload. The
+// orders are stored in the order store with their idempotency key and a hash of their payload. The calls sharing a key
+// are serialized within an instance, and a store shared between the instances of a service rejects the second order
+// created with a key, see IdempotentOrderStore. An instance losing that race cancels the order it started and returns
+// the stored order, so that only one order per key remains. A key that has expired is released by deleting its order
+// from the store. Calls without an idempotency key, and the other methods of BankID, are passed on to the client.
+type IdempotentClient struct {
+	BankID
+
+	store IdempotentOrderStore
+	ttl   time.Duration
+
+	// Serializes the calls sharing an idempotency key
+	keys keyedMutex
+}
+
+// NewIdempotentClient returns a new instance of 'IdempotentClient' starting the orders using the client and storing
+// them in the store.
+func NewIdempotentClient(
+	client BankID,
+	store IdempotentOrderStore,
+	options ...IdempotencyOption,
+) *IdempotentClient {
+	instance := &IdempotentClient{BankID: client, store: store, ttl: defaultIdempotencyTTL}
+
+	// Apply options if there are any, can overwrite default
+	for _, option := range options {
+		option(instance)
+	}
+
+	return instance
+}
+
+// Authenticate - Initiates an authentication order, or returns the order started with the idempotency key of the
+// context.
+//
+// See BankIDClient.Authenticate. It returns ErrIdempotencyKeyReused if the key started another type of order or an
+// order with another payload.
+func (i *IdempotentClient) Authenticate(
+	context context.Context,
+	payload *payload.AuthenticationPayload,
+) (*response.AuthenticateResponse, error) {
+	order, err := i.start(context, OrderTypeAuthenticate, payload, func() (*Order, error) {
+		started, err := i.BankID.Authenticate(context, payload)
+		if err != nil {
+			return nil, err // nolint:wrapcheck
+		}
+
+		return NewAuthenticateOrder(started), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return order.authenticateResponse(), nil
+}
+
+// Sign - Initiates a sign order, or returns the order started with the idempotency key of the context.
+//
+// See BankIDClient.Sign. It returns ErrIdempotencyKeyReused if the key started another type of order or an order with
+// another payload.
+func (i *IdempotentClient) Sign(
+	context context.Context,
+	payload *payload.SignPayload,
+) (*response.SignResponse, error) {
+	order, err := i.start(context, OrderTypeSign, payload, func() (*Order, error) {
+		started, err := i.BankID.Sign(context, payload)
+		if err != nil {
+			return nil, err // nolint:wrapcheck
+		}
+
+		return NewSignOrder(started), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &response.SignResponse{AuthenticateResponse: *order.authenticateResponse()}, nil
+}
+
+// start returns the order started with the idempotency key of the context, or starts and stores a new order.
+func (i *IdempotentClient) start(
+	ctx context.Context,
+	orderType OrderType,
+	request any,
+	start func() (*Order, error),
+) (*Order, error) {
+	idempotencyKey, ok := IdempotencyKeyFromContext(ctx)
+	if !ok {
+		return start()
+	}
+
+	payloadHash, err := hashPayload(request)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock := i.keys.lock(idempotencyKey)
+	defer unlock()
+
+	existing, err := i.store.GetByIdempotencyKey(ctx, idempotencyKey)
+
+	switch {
+	case errors.Is(err, ErrOrderNotFound):
+	case err != nil:
+		return nil, fmt.Errorf("unable to get the order of the idempotency key. %w", err)
+	case time.Since(existing.StartedAt) > i.ttl:
+		// The key has expired, deleting its order releases it for a new order
+		if err := i.store.Delete(ctx, existing.OrderRef); err != nil {
+			return nil, fmt.Errorf("unable to delete the order of the expired idempotency key. %w", err)
+		}
+	default:
+		return reuse(existing, orderType, payloadHash)
+	}
+
+	order, err := start()
+	if err != nil {
+		return nil, err
+	}
+
+	order.IdempotencyKey, order.PayloadHash = idempotencyKey, payloadHash
+
+	err = i.store.Create(ctx, order)
+
+	switch {
+	case errors.Is(err, ErrIdempotencyKeyExists):
+		// Another instance stored an order with the key first, the order started here is not returned to anyone
+		_, _ = i.BankID.Cancel(ctx, &payload.CancelPayload{OrderRef: order.OrderRef})
+
+		existing, err := i.store.GetByIdempotencyKey(ctx, idempotencyKey)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get the order of the idempotency key. %w", err)
+		}
+
+		return reuse(existing, orderType, payloadHash)
+	case err != nil:
+		return nil, fmt.Errorf("unable to store the order. %w", err)
+	}
+
+	return order, nil
+}
+
+// reuse returns the order started with an idempotency key if it was started with the same type and payload.
+func reuse(existing *Order, orderType OrderType, payloadHash string) (*Order, error) {
+	if existing.Type != orderType {
+		return nil, fmt.Errorf("%w. %s", ErrIdempotencyKeyReused, existing.Type)
+	}
+
+	if existing.PayloadHash != payloadHash {
+		return nil, fmt.Errorf("%w. The payload differs", ErrIdempotencyKeyReused)
+	}
+
+	return existing, nil
+}
+
+// hashPayload returns the hex encoded SHA-256 hash of the JSON encoded payload.
+func hashPayload(payload any) (string, error) {
+	content, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("unable to encode the payload. %w", err)
+	}
+
+	hash := sha256.Sum256(content)
+
+	return hex.EncodeToString(hash[:]), nil
+}
@@ -0,0 +1,166 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/e-identification/bankid-go/pkg/payload"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotentClient(t *testing.T) {
+	var started atomic.Int32
+
+	bankID, teardown := testBankID(func(writer http.ResponseWriter, request *http.Request) {
+		body := fmt.Sprintf(`{"orderRef":"order-%d","qrStartToken":"token","qrStartSecret":"secret"}`, started.Add(1))
+		stringToResponseHandler(t, body)(writer, request)
+	})
+	defer teardown()
+
+	client := NewIdempotentClient(bankID, NewMemoryOrderStore())
+	authenticationPayload := &payload.AuthenticationPayload{EndUserIP: "192.168.1.1"}
+	ctx := WithIdempotencyKey(context.Background(), "key")
+
+	first, err := client.Authenticate(ctx, authenticationPayload)
+	assert.NoError(t, err)
+
+	retried, err := client.Authenticate(ctx, authenticationPayload)
+	assert.NoError(t, err)
+	assert.Equal(t, first, retried)
+	assert.Equal(t, "secret", retried.QrStartSecret)
+	assert.Equal(t, int32(1), started.Load())
+
+	_, err = client.Sign(ctx, &payload.SignPayload{EndUserIP: "192.168.1.1", UserVisibleData: "dGVzdA=="})
+	assert.ErrorIs(t, err, ErrIdempotencyKeyReused)
+
+	_, err = client.Authenticate(ctx, &payload.AuthenticationPayload{EndUserIP: "192.168.1.2"})
+	assert.ErrorIs(t, err, ErrIdempotencyKeyReused)
+
+	// Calls without a key or with another key start new orders
+	other, err := client.Authenticate(context.Background(), authenticationPayload)
+	assert.NoError(t, err)
+	assert.NotEqual(t, first.OrderRef, other.OrderRef)
+
+	other, err = client.Authenticate(WithIdempotencyKey(ctx, "other"), authenticationPayload)
+	assert.NoError(t, err)
+	assert.NotEqual(t, first.OrderRef, other.OrderRef)
+	assert.Equal(t, int32(3), started.Load())
+}
+
+func TestIdempotentClientKeyExpires(t *testing.T) {
+	var started atomic.Int32
+
+	bankID, teardown := testBankID(func(writer http.ResponseWriter, request *http.Request) {
+		stringToResponseHandler(t, fmt.Sprintf(`{"orderRef":"order-%d"}`, started.Add(1)))(writer, request)
+	})
+	defer teardown()
+
+	store := NewMemoryOrderStore()
+	client := NewIdempotentClient(bankID, store, WithIdempotencyTTL(time.Millisecond))
+	signPayload := &payload.SignPayload{EndUserIP: "192.168.1.1", UserVisibleData: "dGVzdA=="}
+	ctx := WithIdempotencyKey(context.Background(), "key")
+
+	first, err := client.Sign(ctx, signPayload)
+	assert.NoError(t, err)
+
+	time.Sleep(2 * time.Millisecond)
+
+	second, err := client.Sign(ctx, signPayload)
+	assert.NoError(t, err)
+	assert.NotEqual(t, first.OrderRef, second.OrderRef)
+
+	indexed, err := store.GetByIdempotencyKey(ctx, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, second.OrderRef, indexed.OrderRef)
+}
+
+func TestIdempotentClientConcurrentRetries(t *testing.T) {
+	var started atomic.Int32
+
+	bankID, teardown := testBankID(func(writer http.ResponseWriter, request *http.Request) {
+		stringToResponseHandler(t, fmt.Sprintf(`{"orderRef":"order-%d"}`, started.Add(1)))(writer, request)
+	})
+	defer teardown()
+
+	client := NewIdempotentClient(bankID, NewMemoryOrderStore())
+	ctx := WithIdempotencyKey(context.Background(), "key")
+
+	var waitGroup sync.WaitGroup
+
+	for range 10 {
+		waitGroup.Add(1)
+
+		go func() {
+			defer waitGroup.Done()
+
+			started, err := client.Authenticate(ctx, &payload.AuthenticationPayload{EndUserIP: "192.168.1.1"})
+			assert.NoError(t, err)
+			assert.Equal(t, "order-1", started.OrderRef)
+		}()
+	}
+
+	waitGroup.Wait()
+
+	assert.Equal(t, int32(1), started.Load())
+}
+
+func TestIdempotentClientRetriesAcrossInstances(t *testing.T) {
+	var started, cancelled atomic.Int32
+
+	bankID, teardown := testBankID(func(writer http.ResponseWriter, request *http.Request) {
+		if strings.HasSuffix(request.URL.Path, "/cancel") {
+			cancelled.Add(1)
+			stringToResponseHandler(t, "{}")(writer, request)
+
+			return
+		}
+
+		stringToResponseHandler(t, fmt.Sprintf(`{"orderRef":"order-%d"}`, started.Add(1)))(writer, request)
+	})
+	defer teardown()
+
+	store, err := NewFileOrderStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each instance serializes only its own calls, the store rejects the second order of the key
+	instances := []*IdempotentClient{NewIdempotentClient(bankID, store), NewIdempotentClient(bankID, store)}
+	ctx := WithIdempotencyKey(context.Background(), "key")
+	orderRefs := make([]string, 10)
+
+	var waitGroup sync.WaitGroup
+
+	for index := range orderRefs {
+		waitGroup.Add(1)
+
+		go func() {
+			defer waitGroup.Done()
+
+			started, err := instances[index%2].Authenticate(ctx, &payload.AuthenticationPayload{EndUserIP: "192.168.1.1"})
+			if assert.NoError(t, err) {
+				orderRefs[index] = started.OrderRef
+			}
+		}()
+	}
+
+	waitGroup.Wait()
+
+	stored, err := store.GetByIdempotencyKey(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, orderRef := range orderRefs {
+		assert.Equal(t, stored.OrderRef, orderRef)
+	}
+
+	assert.Equal(t, started.Load()-1, cancelled.Load())
+}
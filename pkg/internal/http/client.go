@@ -2,10 +2,14 @@ package http
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/http/httptrace"
 	"strings"
+	"sync/atomic"
 
 	"github.com/e-identification/bankid-go/pkg/configuration"
 )
@@ -17,6 +21,35 @@ var expectedHTTPStatusCodes = []int{200, 400, 401, 403, 404, 408, 415, 500, 503}
 type Client interface {
 	// Call is responsible for making the HTTP call against BankID REST API
 	Call(context context.Context, request *Request) (Response, error)
+	// Stats returns the statistics of the connections used by the calls
+	Stats() Stats
+	// Close closes the idle connections and stops following the reloads of the client certificate
+	Close()
+}
+
+// Stats holds the statistics of the connections used by the calls.
+type Stats struct {
+	// The number of calls that got a connection.
+	Requests int64
+	// The number of calls that established a new connection.
+	NewConnections int64
+	// The number of calls that reused an idle or, with HTTP/2, a shared connection.
+	ReusedConnections int64
+	// The number of completed TLS handshakes.
+	Handshakes int64
+	// The number of TLS handshakes that resumed a cached session.
+	ResumedHandshakes int64
+	// The number of failed TLS handshakes.
+	FailedHandshakes int64
+}
+
+// stats holds the counters of the Stats.
+type stats struct {
+	newConnections    atomic.Int64
+	reusedConnections atomic.Int64
+	handshakes        atomic.Int64
+	resumedHandshakes atomic.Int64
+	failedHandshakes  atomic.Int64
 }
 
 type client struct {
@@ -24,28 +57,86 @@ type client struct {
 	configuration *configuration.Configuration
 	encoder       encoder
 	decoder       decoder
+	stats         *stats
+	trace         *httptrace.ClientTrace
+	// The transport of the client, which is wrapped by the transport wrapper of the configuration if any
+	transport *http.Transport
+	// Removes the listener of the reloads of the client certificate, if any
+	stopReload func()
 }
 
 // Option definition.
 type Option func(*client)
 
-// NewClient returns a new instance of 'NewClient'.
+// NewClient returns a new instance of 'NewClient' using the client certificate source of the configuration.
 func NewClient(configuration *configuration.Configuration, options ...Option) (Client, error) {
-	clientCfg, err := NewTLSClientConfig(configuration)
+	source, err := configuration.ClientCertificateSource()
+	if err != nil {
+		return nil, fmt.Errorf("unable to load client certificate. %w", err)
+	}
+
+	return NewClientWithCertificateSource(configuration, source, options...)
+}
+
+// NewClientWithCertificateSource returns a new instance of 'NewClient' presenting the client certificate of the
+// source, which is expected to be the client certificate source of the configuration.
+func NewClientWithCertificateSource(
+	configuration *configuration.Configuration,
+	source configuration.CertificateSource,
+	options ...Option,
+) (Client, error) {
+	clientCfg, err := NewTLSClientConfig(configuration, source)
 	if err != nil {
 		return nil, fmt.Errorf("error reading and/or parsing the certification files. %w", err)
 	}
 
+	policy := configuration.TransportPolicy.WithDefaults()
+	dialer := &net.Dialer{KeepAlive: policy.KeepAlive}
+
+	netTransport := &http.Transport{
+		TLSClientConfig:     clientCfg,
+		Proxy:               configuration.Proxy,
+		DialContext:         dialer.DialContext,
+		ForceAttemptHTTP2:   !policy.DisableHTTP2,
+		MaxIdleConns:        policy.MaxIdleConnections,
+		MaxIdleConnsPerHost: policy.MaxIdleConnections,
+		IdleConnTimeout:     policy.IdleTimeout,
+		TLSHandshakeTimeout: policy.HandshakeTimeout,
+	}
+
+	stopReload := func() {}
+
+	// Close idle connections and forget the cached sessions when the client certificate is reloaded so that new
+	// requests handshake with the new certificate, in-flight requests are not affected.
+	if reloadable, ok := source.(interface {
+		OnReload(listener func(*tls.Certificate)) func()
+	}); ok {
+		stopReload = reloadable.OnReload(func(*tls.Certificate) {
+			if cache, ok := clientCfg.ClientSessionCache.(*sessionCache); ok {
+				cache.Clear()
+			}
+
+			netTransport.CloseIdleConnections()
+		})
+	}
+
+	var transport http.RoundTripper = netTransport
+
+	if configuration.TransportWrapper != nil {
+		transport = configuration.TransportWrapper(transport)
+	}
+
 	netClient := http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: clientCfg,
-		},
+		Transport: transport,
+		Timeout:   configuration.Timeout,
 	}
 
 	instance := &client{
 		client: &netClient, configuration: configuration,
-		encoder: newJSONEncoder(), decoder: newJSONDecoder(),
+		encoder: newJSONEncoder(), decoder: newJSONDecoder(), stats: &stats{},
+		transport: netTransport, stopReload: stopReload,
 	}
+	instance.trace = instance.stats.trace()
 
 	// Apply options if there are any, can overwrite default
 	for _, option := range options {
@@ -69,6 +160,9 @@ func (c client) Call(ctx context.Context, request *Request) (Response, error) {
 		return nil, fmt.Errorf("unable to encode payload. %w", err)
 	}
 
+	// The trace updates the statistics, in addition to any trace of the caller
+	ctx = httptrace.WithClientTrace(ctx, c.trace)
+
 	req, err := c.newRequest(ctx, c.urlFrom(request), strings.NewReader(string(encoded)))
 	if err != nil {
 		return nil, fmt.Errorf("unable to create request. %w", err)
@@ -84,6 +178,29 @@ func (c client) Call(ctx context.Context, request *Request) (Response, error) {
 	return c.decoder.decode(request, resp) // nolint:wrapcheck
 }
 
+// Stats returns the statistics of the connections used by the calls.
+func (c client) Stats() Stats {
+	newConnections, reusedConnections := c.stats.newConnections.Load(), c.stats.reusedConnections.Load()
+
+	return Stats{
+		Requests:          newConnections + reusedConnections,
+		NewConnections:    newConnections,
+		ReusedConnections: reusedConnections,
+		Handshakes:        c.stats.handshakes.Load(),
+		ResumedHandshakes: c.stats.resumedHandshakes.Load(),
+		FailedHandshakes:  c.stats.failedHandshakes.Load(),
+	}
+}
+
+// Close closes the idle connections and stops following the reloads of the client certificate. Requests in flight
+// are not affected.
+func (c client) Close() {
+	c.stopReload()
+	// The transport is closed directly, as a wrapper of the transport may not forward the call
+	c.transport.CloseIdleConnections()
+	c.client.CloseIdleConnections()
+}
+
 // newRequest creates and prepares an instance of http Request.
 func (c client) newRequest(context context.Context, url string, body io.Reader) (*http.Request, error) {
 	req, err := http.NewRequestWithContext(context, http.MethodPost, url, body)
@@ -97,9 +214,33 @@ func (c client) newRequest(context context.Context, url string, body io.Reader)
 }
 
 func (c client) urlFrom(request *Request) string {
-	return c.configuration.Environment.BaseURL + "/" + request.URI
+	return strings.TrimRight(c.configuration.Environment.BaseURL, "/") + "/" + strings.TrimLeft(request.URI, "/")
 }
 
 func (c client) request(request *http.Request) (*http.Response, error) {
 	return c.client.Do(request) // nolint:wrapcheck
 }
+
+// trace returns the hooks updating the counters, which are invoked in addition to any trace of the caller.
+func (s *stats) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				s.reusedConnections.Add(1)
+			} else {
+				s.newConnections.Add(1)
+			}
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			switch {
+			case err != nil:
+				s.failedHandshakes.Add(1)
+			case state.DidResume:
+				s.handshakes.Add(1)
+				s.resumedHandshakes.Add(1)
+			default:
+				s.handshakes.Add(1)
+			}
+		},
+	}
+}
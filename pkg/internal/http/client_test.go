@@ -0,0 +1,191 @@
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/e-identification/bankid-go/pkg/configuration"
+	"github.com/e-identification/bankid-go/pkg/response"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCallThroughAuthenticatingProxy(t *testing.T) {
+	server := newMutualTLSServer(t, func(writer http.ResponseWriter, request *http.Request) {
+		assert.Equal(t, "RP", request.TLS.PeerCertificates[0].Subject.CommonName)
+
+		writer.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(writer, `{}`)
+	})
+
+	var tunnels atomic.Int32
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		credentials := base64.StdEncoding.EncodeToString([]byte("user:secret"))
+		if request.Method != http.MethodConnect || request.Header.Get("Proxy-Authorization") != "Basic "+credentials {
+			writer.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+
+		tunnels.Add(1)
+		tunnel(t, writer, request.Host)
+	}))
+	t.Cleanup(proxy.Close)
+
+	proxyURL, _ := url.Parse(proxy.URL)
+	proxyURL.User = url.UserPassword("user", "secret")
+
+	client, err := NewClient(configuration.NewKeyPairConfiguration(testEnvironment(server), newTestKeyPair(t, "RP"),
+		configuration.WithProxy(proxyURL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.Call(context.Background(), &Request{
+		URI: "collect", Payload: struct{}{}, Response: &response.CollectResponse{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, int32(1), tunnels.Load())
+}
+
+// tunnel hijacks the connection of a CONNECT request and pipes it to the target address.
+func tunnel(t *testing.T, writer http.ResponseWriter, address string) {
+	t.Helper()
+
+	target, err := net.Dial("tcp", address)
+	if err != nil {
+		writer.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	writer.WriteHeader(http.StatusOK)
+
+	connection, _, err := writer.(http.Hijacker).Hijack()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	go func() {
+		_, _ = io.Copy(target, connection)
+		_ = target.Close()
+	}()
+
+	go func() {
+		_, _ = io.Copy(connection, target)
+		_ = connection.Close()
+	}()
+}
+
+func TestCallsShareAnHTTP2Connection(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		assert.Equal(t, 2, request.ProtoMajor)
+
+		writer.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(writer, `{}`)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert, MinVersion: tls.VersionTLS12}
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	client, err := NewClient(configuration.NewKeyPairConfiguration(testEnvironment(server), newTestKeyPair(t, "RP")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		if _, err := client.Call(context.Background(), &Request{
+			URI: "collect", Payload: struct{}{}, Response: &response.CollectResponse{},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	assert.Equal(t, Stats{Requests: 2, NewConnections: 1, ReusedConnections: 1, Handshakes: 1}, client.Stats())
+}
+
+func TestCloseClosesIdleConnections(t *testing.T) {
+	var (
+		mutex       sync.Mutex
+		connections = map[string]bool{}
+	)
+
+	server := newMutualTLSServer(t, func(writer http.ResponseWriter, request *http.Request) {
+		mutex.Lock()
+		connections[request.RemoteAddr] = true
+		mutex.Unlock()
+
+		writer.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(writer, `{}`)
+	})
+
+	// The idle connections are closed through a transport wrapper
+	wrapper := func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(next.RoundTrip)
+	}
+
+	client, err := NewClient(configuration.NewKeyPairConfiguration(testEnvironment(server), newTestKeyPair(t, "RP"),
+		configuration.WithTransportWrapper(wrapper)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		if _, err := client.Call(context.Background(), &Request{
+			URI: "collect", Payload: struct{}{}, Response: &response.CollectResponse{},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		client.Close()
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	assert.Len(t, connections, 2)
+}
+
+func TestNewConnectionsResumeTheTLSSession(t *testing.T) {
+	server := newMutualTLSServer(t, func(writer http.ResponseWriter, _ *http.Request) {
+		writer.Header().Set("Connection", "close")
+		writer.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(writer, `{}`)
+	})
+
+	client, err := NewClient(configuration.NewKeyPairConfiguration(testEnvironment(server), newTestKeyPair(t, "RP"),
+		configuration.WithTransportPolicy(&configuration.TransportPolicy{DisableHTTP2: true})))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		if _, err := client.Call(context.Background(), &Request{
+			URI: "collect", Payload: struct{}{}, Response: &response.CollectResponse{},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	assert.Equal(t, Stats{Requests: 2, NewConnections: 2, Handshakes: 2, ResumedHandshakes: 1}, client.Stats())
+}
+
+// roundTripperFunc adapts a function to a http.RoundTripper.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(request *http.Request) (*http.Response, error) {
+	return f(request)
+}
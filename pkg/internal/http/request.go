@@ -1,11 +1,15 @@
 package http
 
+import "github.com/e-identification/bankid-go/pkg/configuration"
+
 // Payload is the interface implemented by types that holds the fields to be delivered to the API.
 type Payload any
 
 // Request holds the field related to http request.
 type Request struct {
-	URI           string
+	URI string
+	// The version of the BankID RP API the endpoint requires, the zero value if any version will do.
+	MinVersion    configuration.APIVersion
 	Payload       Payload
 	Response      Response
 	ErrorResponse error
@@ -1,34 +1,57 @@
 package http
 
 import (
-	"crypto"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/e-identification/bankid-go/pkg/configuration"
-
-	"software.sslmate.com/src/go-pkcs12"
 )
 
-// NewTLSClientConfig initiates a new tls.Config.
-func NewTLSClientConfig(configuration *configuration.Configuration) (*tls.Config, error) {
+// NewTLSClientConfig initiates a new tls.Config presenting the client certificate of the source.
+func NewTLSClientConfig(
+	configuration *configuration.Configuration,
+	source configuration.CertificateSource,
+) (*tls.Config, error) {
 	caPool, err := createCertPool(configuration.Environment.Certificate)
 	if err != nil {
 		return nil, err
 	}
 
-	rpCert, err := createCertLeaf(configuration)
-	if err != nil {
-		return nil, err
+	policy := tlsPolicyOrDefault(configuration.TLSPolicy)
+
+	if err := policy.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid tls policy. %w", err)
+	}
+
+	minVersion := policy.MinVersion
+	if minVersion == 0 {
+		minVersion = tls.VersionTLS12
 	}
 
-	// #nosec G402
 	clientCfg := &tls.Config{
-		Certificates: []tls.Certificate{*rpCert},
-		ClientCAs:    caPool,
-		RootCAs:      caPool,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			certificate, err := source.ClientCertificate()
+			if err != nil {
+				return nil, err // nolint:wrapcheck
+			}
+
+			checkExpiry(configuration.ExpiryPolicy, certificate)
+
+			return certificate, nil
+		},
+		RootCAs:          caPool,
+		MinVersion:       minVersion,
+		CipherSuites:     policy.CipherSuites,
+		VerifyConnection: policy.VerifyConnection,
+	}
+
+	// Resumed sessions skip the certificate exchange when a new connection is established
+	if size := configuration.TransportPolicy.WithDefaults().SessionCacheSize; size > 0 {
+		clientCfg.ClientSessionCache = newSessionCache(size)
 	}
 
 	return clientCfg, nil
@@ -48,17 +71,59 @@ func createCertPool(base64EncodedCertificate string) (*x509.CertPool, error) {
 	return caPool, nil
 }
 
-func createCertLeaf(configuration *configuration.Configuration) (*tls.Certificate, error) {
-	key, leaf, err := pkcs12.Decode(configuration.Pkcs12.Content, configuration.Pkcs12.Password)
-	if err != nil {
-		return nil, fmt.Errorf("unable to load pkcs12. %w", err)
+// checkExpiry checks the certificate against the expiry policy during a handshake. The policy only warns here, it is
+// up to the BankID RP API to refuse the certificate.
+func checkExpiry(policy *configuration.ExpiryPolicy, certificate *tls.Certificate) {
+	if policy == nil {
+		return
 	}
 
-	cert := &tls.Certificate{
-		Certificate: [][]byte{leaf.Raw},
-		PrivateKey:  key.(crypto.PrivateKey),
-		Leaf:        leaf,
+	if leaf, err := configuration.LeafCertificate(certificate); err == nil {
+		_ = policy.Check(leaf, time.Now())
+	}
+}
+
+// tlsPolicyOrDefault returns the policy, or the default policy if nil.
+func tlsPolicyOrDefault(policy *configuration.TLSPolicy) *configuration.TLSPolicy {
+	if policy == nil {
+		return &configuration.TLSPolicy{}
 	}
 
-	return cert, nil
+	return policy
+}
+
+// sessionCache is a tls.ClientSessionCache that can be cleared. A resumed session keeps the client certificate of the
+// session it resumes, the cache is therefore cleared when the client certificate is reloaded.
+type sessionCache struct {
+	mutex sync.Mutex
+	size  int
+	cache tls.ClientSessionCache
+}
+
+func newSessionCache(size int) *sessionCache {
+	return &sessionCache{size: size, cache: tls.NewLRUClientSessionCache(size)}
+}
+
+// Get implements tls.ClientSessionCache.
+func (c *sessionCache) Get(sessionKey string) (*tls.ClientSessionState, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.cache.Get(sessionKey)
+}
+
+// Put implements tls.ClientSessionCache.
+func (c *sessionCache) Put(sessionKey string, state *tls.ClientSessionState) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.cache.Put(sessionKey, state)
+}
+
+// Clear removes all cached sessions.
+func (c *sessionCache) Clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.cache = tls.NewLRUClientSessionCache(c.size)
 }
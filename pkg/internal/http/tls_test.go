@@ -0,0 +1,315 @@
+package http
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/e-identification/bankid-go/pkg/configuration"
+	"github.com/e-identification/bankid-go/pkg/response"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// softwareSigner is a crypto.Signer that hides the concrete private key, as PKCS#11 and KMS adapters do.
+type softwareSigner struct {
+	key   crypto.Signer
+	signs atomic.Int32
+}
+
+func (s *softwareSigner) Public() crypto.PublicKey {
+	return s.key.Public()
+}
+
+func (s *softwareSigner) Sign(random io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	s.signs.Add(1)
+
+	return s.key.Sign(random, digest, opts) // nolint:wrapcheck
+}
+
+func TestClientCertificateBackedBySigner(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer := &softwareSigner{key: key}
+	leaf := newTestCertificate(t, "RP", signer)
+
+	server := newMutualTLSServer(t, func(writer http.ResponseWriter, request *http.Request) {
+		assert.Equal(t, "RP", request.TLS.PeerCertificates[0].Subject.CommonName)
+
+		writer.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(writer, `{"orderRef":"131daac9-16c6-4618-beb0-365768f37288"}`)
+	})
+
+	keyPair, err := configuration.NewKeyPair([]*x509.Certificate{leaf}, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := NewClient(configuration.NewKeyPairConfiguration(testEnvironment(server), keyPair))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := client.Call(context.Background(), &Request{
+		URI: "auth", Payload: struct{}{}, Response: &response.AuthenticateResponse{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, "131daac9-16c6-4618-beb0-365768f37288", result.(*response.AuthenticateResponse).OrderRef)
+	assert.Positive(t, signer.signs.Load())
+}
+
+func TestNewKeyPairWithMismatchingSigner(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = configuration.NewKeyPair([]*x509.Certificate{newTestCertificate(t, "RP", key)}, otherKey)
+	assert.ErrorContains(t, err, "private key does not match the certificate")
+}
+
+// newMutualTLSServer starts a TLS server that requires a client certificate.
+func newMutualTLSServer(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewUnstartedServer(handler)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert, MinVersion: tls.VersionTLS12}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// testEnvironment returns an environment trusting the certificate of the test server.
+func testEnvironment(server *httptest.Server) *configuration.Environment {
+	certificatePEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	return configuration.NewEnvironment(server.URL, base64.StdEncoding.EncodeToString(certificatePEM))
+}
+
+func newTestCertificate(t *testing.T, commonName string, key crypto.Signer) *x509.Certificate {
+	t.Helper()
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return certificate
+}
+
+func TestClientCertificateRotation(t *testing.T) {
+	first, second := newTestKeyPair(t, "first"), newTestKeyPair(t, "second")
+
+	var presented []string
+
+	server := newMutualTLSServer(t, func(writer http.ResponseWriter, request *http.Request) {
+		presented = append(presented, request.TLS.PeerCertificates[0].Subject.CommonName)
+
+		writer.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(writer, `{}`)
+	})
+
+	current := first
+
+	reloadable, err := configuration.NewReloadableCertificate(func() (*tls.Certificate, error) {
+		return current.Certificate()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := NewClient(configuration.NewConfiguration(testEnvironment(server), nil,
+		configuration.WithCertificateSource(reloadable)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	call := func() {
+		if _, err := client.Call(context.Background(), &Request{
+			URI: "collect", Payload: struct{}{}, Response: &response.CollectResponse{},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	call()
+	call()
+
+	current = second
+
+	if err := reloadable.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	call()
+
+	assert.Equal(t, []string{"first", "first", "second"}, presented)
+}
+
+func newTestKeyPair(t *testing.T, commonName string) *configuration.KeyPair {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyPair, err := configuration.NewKeyPair([]*x509.Certificate{newTestCertificate(t, commonName, key)}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return keyPair
+}
+
+func TestTLSPolicyPinning(t *testing.T) {
+	server := newMutualTLSServer(t, func(writer http.ResponseWriter, _ *http.Request) {
+		writer.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(writer, `{}`)
+	})
+
+	serverPin := configuration.SPKIPin(server.Certificate())
+	otherPin := configuration.SPKIPin(newTestCertificate(t, "other", newTestKeyPair(t, "other").PrivateKey))
+
+	tests := []struct {
+		name    string
+		policy  *configuration.TLSPolicy
+		wantErr error
+	}{
+		{name: "Pin", policy: &configuration.TLSPolicy{Pins: []string{serverPin}, BackupPins: []string{otherPin}}},
+		{name: "BackupPin", policy: &configuration.TLSPolicy{Pins: []string{otherPin}, BackupPins: []string{serverPin}}},
+		{
+			name:    "Mismatch",
+			policy:  &configuration.TLSPolicy{Pins: []string{otherPin}, BackupPins: []string{otherPin}},
+			wantErr: configuration.ErrCertificatePinMismatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var chains [][]*x509.Certificate
+
+			tt.policy.VerifyChain = func(verifiedChains [][]*x509.Certificate) error {
+				chains = verifiedChains
+				return nil
+			}
+
+			client, err := NewClient(configuration.NewKeyPairConfiguration(testEnvironment(server),
+				newTestKeyPair(t, "RP"), configuration.WithTLSPolicy(tt.policy)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = client.Call(context.Background(), &Request{
+				URI: "collect", Payload: struct{}{}, Response: &response.CollectResponse{},
+			})
+
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				assert.Nil(t, chains)
+
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, server.Certificate().Raw, chains[0][0].Raw)
+		})
+	}
+}
+
+func TestTLSPolicyMinVersion(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12} // nolint:gosec
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	client, err := NewClient(configuration.NewKeyPairConfiguration(testEnvironment(server), newTestKeyPair(t, "RP"),
+		configuration.WithTLSPolicy(&configuration.TLSPolicy{MinVersion: tls.VersionTLS13})))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.Call(context.Background(), &Request{
+		URI: "collect", Payload: struct{}{}, Response: &response.CollectResponse{},
+	})
+	assert.ErrorContains(t, err, "protocol version")
+}
+
+func TestTLSPolicyValidation(t *testing.T) {
+	environment := configuration.NewEnvironment("https://bankid.example.com", configuration.TestEnvironment.Certificate)
+
+	tests := []struct {
+		name    string
+		policy  *configuration.TLSPolicy
+		wantErr string
+	}{
+		{name: "MinVersion", policy: &configuration.TLSPolicy{MinVersion: tls.VersionTLS10}, wantErr: "TLS 1.0"},
+		{
+			name: "CipherSuite", policy: &configuration.TLSPolicy{CipherSuites: []uint16{tls.TLS_RSA_WITH_RC4_128_SHA}},
+			wantErr: "cipher suite TLS_RSA_WITH_RC4_128_SHA is not allowed",
+		},
+		{
+			name:    "BackupPins",
+			policy:  &configuration.TLSPolicy{Pins: []string{"47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="}},
+			wantErr: "backup pins are required",
+		},
+		{
+			name: "Pin", policy: &configuration.TLSPolicy{Pins: []string{"pin"}, BackupPins: []string{"backup"}},
+			wantErr: `pin "pin" is not a base64 encoded SHA-256 hash`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientConfiguration := configuration.NewKeyPairConfiguration(environment, newTestKeyPair(t, "RP"),
+				configuration.WithTLSPolicy(tt.policy))
+
+			source, err := clientConfiguration.ClientCertificateSource()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = NewTLSClientConfig(clientConfiguration, source)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
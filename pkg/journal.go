@@ -0,0 +1,354 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/e-identification/bankid-go/pkg/response"
+)
+
+// The operations of the journal records.
+const (
+	journalCreate = "create"
+	journalUpdate = "update"
+	journalDelete = "delete"
+)
+
+// defaultJournalRetention is the time Compact keeps the orders that are no longer pending after they were started.
+const defaultJournalRetention = OrderLifetime
+
+// journalRecord is a line of the journal.
+type journalRecord struct {
+	Operation string          `json:"op"`
+	Order     *Order          `json:"order,omitempty"`
+	OrderRef  string          `json:"orderRef,omitempty"`
+	Status    response.Status `json:"status,omitempty"`
+	HintCode  string          `json:"hintCode,omitempty"`
+	Time      time.Time       `json:"time"`
+}
+
+// JournalOption definition.
+type JournalOption func(*OrderJournal)
+
+// WithJournalRetention Function to create JournalOption func to set the time Compact keeps the orders that are no
+// longer pending after they were started, defaults to OrderLifetime. It should be at least the TTL of an
+// IdempotentClient using the journal, so that the idempotency keys outlive a compaction.
+func WithJournalRetention(retention time.Duration) JournalOption {
+	return func(subject *OrderJournal) {
+		subject.retention = retention
+	}
+}
+
+// To ensure that OrderJournal implements the IdempotentOrderStore interface.
+var _ IdempotentOrderStore = (*OrderJournal)(nil)
+
+// OrderJournal is an OrderStore that appends every change of the orders to a file, which survives a restart of the
+// process.
+//
+// Each change is written as a JSON line and synced to disk before it is acknowledged. When the journal is opened the
+// file is replayed to rebuild the orders, and a last line torn by a crash is discarded. The journal only grows, use
+// Compact to rewrite it with the pending and recent orders. See BankIDClient.Resume for how to handle the orders that
+// were pending when the process stopped.
+type OrderJournal struct {
+	path      string
+	file      *os.File
+	mutex     sync.Mutex
+	orders    *MemoryOrderStore
+	retention time.Duration
+}
+
+// OpenOrderJournal opens the journal at the path, creating the file if it does not exist, and replays it.
+func OpenOrderJournal(path string, options ...JournalOption) (*OrderJournal, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600) // #nosec G304
+	if err != nil {
+		return nil, fmt.Errorf("unable to open the order journal. %w", err)
+	}
+
+	journal := &OrderJournal{path: path, file: file, orders: NewMemoryOrderStore(), retention: defaultJournalRetention}
+
+	// Apply options if there are any, can overwrite default
+	for _, option := range options {
+		option(journal)
+	}
+
+	if err := journal.replay(); err != nil {
+		_ = file.Close()
+
+		return nil, err
+	}
+
+	return journal, nil
+}
+
+// Create appends the order to the journal.
+func (j *OrderJournal) Create(ctx context.Context, order *Order) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if _, err := j.orders.Get(ctx, order.OrderRef); err == nil {
+		return fmt.Errorf("%w. %s", ErrOrderExists, order.OrderRef)
+	}
+
+	if _, err := j.orders.GetByIdempotencyKey(ctx, order.IdempotencyKey); err == nil && order.IdempotencyKey != "" {
+		return fmt.Errorf("%w. %s", ErrIdempotencyKeyExists, order.IdempotencyKey)
+	}
+
+	if err := j.append(journalRecord{Operation: journalCreate, Order: order, Time: time.Now()}); err != nil {
+		return err
+	}
+
+	return j.orders.Create(ctx, order)
+}
+
+// Get returns the order.
+func (j *OrderJournal) Get(ctx context.Context, orderRef string) (*Order, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	return j.orders.Get(ctx, orderRef)
+}
+
+// UpdateStatus appends the status and hint code of the order to the journal.
+func (j *OrderJournal) UpdateStatus(ctx context.Context, orderRef string, status response.Status,
+	hintCode string,
+) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if _, err := j.orders.Get(ctx, orderRef); err != nil {
+		return err
+	}
+
+	record := journalRecord{
+		Operation: journalUpdate, OrderRef: orderRef, Status: status, HintCode: hintCode, Time: time.Now(),
+	}
+	if err := j.append(record); err != nil {
+		return err
+	}
+
+	return j.orders.UpdateStatus(ctx, orderRef, status, hintCode)
+}
+
+// Delete appends the removal of the order to the journal.
+func (j *OrderJournal) Delete(ctx context.Context, orderRef string) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if _, err := j.orders.Get(ctx, orderRef); errors.Is(err, ErrOrderNotFound) {
+		return nil
+	}
+
+	if err := j.append(journalRecord{Operation: journalDelete, OrderRef: orderRef, Time: time.Now()}); err != nil {
+		return err
+	}
+
+	return j.orders.Delete(ctx, orderRef)
+}
+
+// ListPending returns the orders that are pending.
+func (j *OrderJournal) ListPending(ctx context.Context) ([]*Order, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	return j.orders.ListPending(ctx)
+}
+
+// GetByIdempotencyKey returns the latest order created with the idempotency key.
+func (j *OrderJournal) GetByIdempotencyKey(ctx context.Context, idempotencyKey string) (*Order, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	return j.orders.GetByIdempotencyKey(ctx, idempotencyKey)
+}
+
+// Compact rewrites the journal with the orders that are still pending or were started within the retention, see
+// WithJournalRetention, dropping the older complete and failed orders.
+func (j *OrderJournal) Compact() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	// The recent orders are kept for the idempotency keys they were started with
+	kept := j.orders.filter(func(order *Order) bool {
+		return order.IsPending() || time.Since(order.StartedAt) < j.retention
+	})
+
+	var content bytes.Buffer
+
+	for _, order := range kept {
+		line, err := json.Marshal(journalRecord{Operation: journalCreate, Order: order, Time: order.UpdatedAt})
+		if err != nil {
+			return fmt.Errorf("unable to encode the order journal. %w", err)
+		}
+
+		content.Write(append(line, '\n'))
+	}
+
+	temporary := j.path + ".compact"
+
+	if err := writeSynced(temporary, content.Bytes()); err != nil {
+		return fmt.Errorf("unable to compact the order journal. %w", err)
+	}
+
+	// The compacted file is opened before it replaces the journal, the appends follow it through the rename
+	file, err := os.OpenFile(temporary, os.O_RDWR|os.O_APPEND, 0o600) // #nosec G304
+	if err != nil {
+		_ = os.Remove(temporary)
+
+		return fmt.Errorf("unable to compact the order journal. %w", err)
+	}
+
+	if err := os.Rename(temporary, j.path); err != nil {
+		_ = file.Close()
+		_ = os.Remove(temporary)
+
+		return fmt.Errorf("unable to compact the order journal. %w", err)
+	}
+
+	_ = j.file.Close()
+	j.file = file
+
+	compacted := NewMemoryOrderStore()
+	for _, order := range kept {
+		_ = compacted.Create(context.Background(), order)
+	}
+
+	j.orders = compacted
+
+	// The rename is only durable once the directory is synced
+	if err := syncDirectory(filepath.Dir(j.path)); err != nil {
+		return fmt.Errorf("unable to sync the compacted order journal. %w", err)
+	}
+
+	return nil
+}
+
+// Close closes the journal file.
+func (j *OrderJournal) Close() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	return j.file.Close() // nolint:wrapcheck
+}
+
+// replay rebuilds the orders from the journal and positions the file for appending. A last line that is incomplete
+// or cannot be decoded was torn by a crash and is truncated.
+func (j *OrderJournal) replay() error {
+	reader := bufio.NewReader(j.file)
+	ctx := context.Background()
+
+	var offset int64
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return fmt.Errorf("unable to read the order journal. %w", err)
+		}
+
+		var record journalRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			if _, peekErr := reader.Peek(1); peekErr == nil {
+				return fmt.Errorf("unable to decode the order journal at offset %d. %w", offset, err)
+			}
+
+			break
+		}
+
+		j.apply(ctx, record)
+
+		offset += int64(len(line))
+	}
+
+	if err := j.file.Truncate(offset); err != nil {
+		return fmt.Errorf("unable to truncate the order journal. %w", err)
+	}
+
+	if _, err := j.file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("unable to seek the order journal. %w", err)
+	}
+
+	return nil
+}
+
+// apply applies a replayed record to the orders.
+func (j *OrderJournal) apply(ctx context.Context, record journalRecord) {
+	switch record.Operation {
+	case journalCreate:
+		if record.Order != nil {
+			j.orders.put(record.Order)
+		}
+	case journalUpdate:
+		if order, err := j.orders.Get(ctx, record.OrderRef); err == nil {
+			order.Status, order.HintCode, order.UpdatedAt = record.Status, record.HintCode, record.Time
+			j.orders.put(order)
+		}
+	case journalDelete:
+		_ = j.orders.Delete(ctx, record.OrderRef)
+	}
+}
+
+// append writes the record and syncs the file, the journal is expected to be locked.
+func (j *OrderJournal) append(record journalRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("unable to encode the journal record. %w", err)
+	}
+
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("unable to append to the order journal. %w", err)
+	}
+
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("unable to sync the order journal. %w", err)
+	}
+
+	return nil
+}
+
+func writeSynced(path string, content []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600) // #nosec G304
+	if err != nil {
+		return err // nolint:wrapcheck
+	}
+
+	if _, err := file.Write(content); err != nil {
+		_ = file.Close()
+
+		return err // nolint:wrapcheck
+	}
+
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+
+		return err // nolint:wrapcheck
+	}
+
+	return file.Close() // nolint:wrapcheck
+}
+
+func syncDirectory(path string) error {
+	directory, err := os.Open(path) // #nosec G304
+	if err != nil {
+		return err // nolint:wrapcheck
+	}
+
+	if err := directory.Sync(); err != nil {
+		_ = directory.Close()
+
+		return err // nolint:wrapcheck
+	}
+
+	return directory.Close() // nolint:wrapcheck
+}
@@ -0,0 +1,178 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/e-identification/bankid-go/pkg/response"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrderJournalReplaysAfterReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.journal")
+	ctx := context.Background()
+
+	journal, err := OpenOrderJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = journal.Create(ctx, NewPhoneAuthenticateOrder(&response.PhoneAuthenticateResponse{OrderRef: "first"}))
+	_ = journal.Create(ctx, NewPhoneAuthenticateOrder(&response.PhoneAuthenticateResponse{OrderRef: "second"}))
+	_ = journal.UpdateStatus(ctx, "first", response.StatusPending, "outstandingTransaction")
+	_ = journal.Delete(ctx, "second")
+	_ = journal.Close()
+
+	// A crash while appending leaves a torn last line
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	_, _ = file.WriteString(`{"op":"create","order":{"orderRef":"th`)
+	_ = file.Close()
+
+	journal, err = OpenOrderJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer journal.Close() // nolint:errcheck
+
+	pending, _ := journal.ListPending(ctx)
+	assert.Len(t, pending, 1)
+	assert.Equal(t, "first", pending[0].OrderRef)
+	assert.Equal(t, "outstandingTransaction", pending[0].HintCode)
+
+	assert.NoError(t, journal.Create(ctx, NewPhoneSignOrder(&response.PhoneSignResponse{
+		PhoneAuthenticateResponse: response.PhoneAuthenticateResponse{OrderRef: "third"},
+	})))
+
+	content, _ := os.ReadFile(path)
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		assert.True(t, json.Valid([]byte(line)), line)
+	}
+}
+
+func TestOrderJournalCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.journal")
+	ctx := context.Background()
+
+	journal, err := OpenOrderJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer journal.Close() // nolint:errcheck
+
+	for _, orderRef := range []string{"first", "second", "third", "fourth"} {
+		order := NewPhoneAuthenticateOrder(&response.PhoneAuthenticateResponse{OrderRef: orderRef})
+		order.IdempotencyKey = orderRef
+
+		if orderRef == "first" {
+			order.StartedAt = time.Now().Add(-defaultJournalRetention)
+		}
+
+		_ = journal.Create(ctx, order)
+		_ = journal.UpdateStatus(ctx, orderRef, response.StatusPending, "userSign")
+	}
+
+	// The old complete order is dropped, the recent complete order is kept for its idempotency key
+	_ = journal.UpdateStatus(ctx, "first", response.StatusComplete, "")
+	_ = journal.UpdateStatus(ctx, "fourth", response.StatusComplete, "")
+	_ = journal.Delete(ctx, "second")
+
+	if err := journal.Compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	content, _ := os.ReadFile(path)
+	assert.Equal(t, 2, strings.Count(string(content), "\n"))
+
+	_ = journal.UpdateStatus(ctx, "third", response.StatusPending, "started")
+
+	reopened, err := OpenOrderJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer reopened.Close() // nolint:errcheck
+
+	third, _ := reopened.Get(ctx, "third")
+	assert.Equal(t, "started", third.HintCode)
+
+	fourth, err := reopened.GetByIdempotencyKey(ctx, "fourth")
+	if assert.NoError(t, err) {
+		assert.Equal(t, response.StatusComplete, fourth.Status)
+	}
+
+	_, err = reopened.GetByIdempotencyKey(ctx, "first")
+	assert.ErrorIs(t, err, ErrOrderNotFound)
+}
+
+func TestOrderJournalCompactRetention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.journal")
+	ctx := context.Background()
+
+	journal, err := OpenOrderJournal(path, WithJournalRetention(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer journal.Close() // nolint:errcheck
+
+	_ = journal.Create(ctx, NewPhoneAuthenticateOrder(&response.PhoneAuthenticateResponse{OrderRef: "order"}))
+	_ = journal.UpdateStatus(ctx, "order", response.StatusFailed, "userCancel")
+
+	if err := journal.Compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = journal.Get(ctx, "order")
+	assert.ErrorIs(t, err, ErrOrderNotFound)
+}
+
+func TestResume(t *testing.T) {
+	var cancels atomic.Int32
+
+	bankID, teardown := testBankID(func(writer http.ResponseWriter, request *http.Request) {
+		assert.Equal(t, "/rp/v6.0/cancel", request.URL.Path)
+		cancels.Add(1)
+		stringToResponseHandler(t, "{}")(writer, request)
+	})
+	defer teardown()
+
+	ctx := context.Background()
+	store := NewMemoryOrderStore()
+
+	_ = store.Create(ctx, NewAuthenticateOrder(&response.AuthenticateResponse{
+		OrderRef: "authenticate", TimeOfResponse: time.Now(),
+	}))
+	_ = store.Create(ctx, NewSignOrder(&response.SignResponse{AuthenticateResponse: response.AuthenticateResponse{
+		OrderRef: "sign", TimeOfResponse: time.Now(),
+	}}))
+	_ = store.Create(ctx, NewAuthenticateOrder(&response.AuthenticateResponse{
+		OrderRef: "expired", TimeOfResponse: time.Now().Add(-time.Hour),
+	}))
+
+	report, err := bankID.Resume(ctx, store, ResumePolicy{
+		Actions: map[OrderType]ResumeAction{OrderTypeSign: ResumeCancel},
+		MaxAge:  10 * time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Len(t, report.Collect, 1)
+	assert.Equal(t, "authenticate", report.Collect[0].OrderRef)
+	assert.Equal(t, []string{"sign"}, report.Cancelled)
+	assert.Equal(t, []string{"expired"}, report.Forgotten)
+	assert.Equal(t, int32(1), cancels.Load())
+
+	pending, _ := store.ListPending(ctx)
+	assert.Len(t, pending, 1)
+}
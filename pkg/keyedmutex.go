@@ -0,0 +1,46 @@
+package pkg
+
+import "sync"
+
+// keyedMutex serializes the callers locking the same key, the zero value is ready to use.
+type keyedMutex struct {
+	mutex sync.Mutex
+	locks map[string]*keyedLock
+}
+
+// keyedLock is the lock of a key, it is removed when no caller holds or waits for it.
+type keyedLock struct {
+	sync.Mutex
+	references int
+}
+
+// lock locks the key, the returned function unlocks it.
+func (k *keyedMutex) lock(key string) func() {
+	k.mutex.Lock()
+
+	if k.locks == nil {
+		k.locks = map[string]*keyedLock{}
+	}
+
+	lock, ok := k.locks[key]
+	if !ok {
+		lock = &keyedLock{}
+		k.locks[key] = lock
+	}
+
+	lock.references++
+	k.mutex.Unlock()
+
+	lock.Lock()
+
+	return func() {
+		lock.Unlock()
+
+		k.mutex.Lock()
+		defer k.mutex.Unlock()
+
+		if lock.references--; lock.references == 0 {
+			delete(k.locks, key)
+		}
+	}
+}
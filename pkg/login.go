@@ -0,0 +1,411 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/e-identification/bankid-go/pkg/payload"
+	"github.com/e-identification/bankid-go/pkg/response"
+)
+
+// defaultMaxRestarts is the number of times a login session starts a new order by default.
+const defaultMaxRestarts = 3
+
+// autoStartBaseURL is the universal link that launches the BankID app on the device of the user.
+const autoStartBaseURL = "https://app.bankid.com/"
+
+// LoginPath is the way a user started the order of a login session.
+type LoginPath string
+
+const (
+	// LoginPathUnknown is the path of an order that the user has not started yet.
+	LoginPathUnknown = LoginPath("")
+	// LoginPathSameDevice is the path of an order started by launching the BankID app with the autostart token.
+	LoginPathSameDevice = LoginPath("sameDevice")
+	// LoginPathOtherDevice is the path of an order taken to have been started by scanning the QR code with another
+	// device, see LoginSession for the limits of the detection.
+	LoginPathOtherDevice = LoginPath("otherDevice")
+)
+
+// AutoStartLink returns the link that launches the BankID app on the device of the user with the autostart token. The
+// app returns to the redirect URL once the order is done, an empty redirect leaves the user in the app.
+func AutoStartLink(autoStartToken string, redirect string) string {
+	if redirect == "" {
+		redirect = "null"
+	}
+
+	query := url.Values{"autostarttoken": {autoStartToken}, "redirect": {redirect}}
+
+	return autoStartBaseURL + "?" + query.Encode()
+}
+
+// LoginOption definition.
+type LoginOption func(*LoginOrchestrator)
+
+// WithMaxRestarts Function to create LoginOption func to set the number of times a login session starts a new order
+// after the order failed to start or expired, defaults to three.
+func WithMaxRestarts(maxRestarts int) LoginOption {
+	return func(subject *LoginOrchestrator) {
+		subject.maxRestarts = maxRestarts
+	}
+}
+
+// LoginOrchestrator starts login sessions offering both the same device and the other device path for one order.
+type LoginOrchestrator struct {
+	client      BankID
+	maxRestarts int
+}
+
+// NewLoginOrchestrator returns a new instance of 'LoginOrchestrator' starting the orders using the client.
+func NewLoginOrchestrator(client BankID, options ...LoginOption) *LoginOrchestrator {
+	instance := &LoginOrchestrator{client: client, maxRestarts: defaultMaxRestarts}
+
+	// Apply options if there are any, can overwrite default
+	for _, option := range options {
+		option(instance)
+	}
+
+	return instance
+}
+
+// Start starts a login session with an authentication order.
+func (l *LoginOrchestrator) Start(
+	ctx context.Context,
+	requestPayload *payload.AuthenticationPayload,
+) (*LoginSession, error) {
+	session := &LoginSession{
+		client: l.client, payload: requestPayload, maxRestarts: l.maxRestarts, changed: make(chan struct{}),
+	}
+
+	if err := session.start(ctx); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// LoginStatus is the status of a login session after a collect.
+type LoginStatus struct {
+	// The current order of the session, which is replaced when the session restarts.
+	Order *Order
+	// The phase of the order.
+	Phase OrderPhase
+	// The hint code of the order.
+	HintCode string
+	// The way the user started the order, LoginPathUnknown until the hint codes tell.
+	Path LoginPath
+	// True if the collect replaced an order that failed to start or expired with a new order.
+	Restarted bool
+	// The completion data of a complete order.
+	CompletionData *response.CompletionData
+}
+
+// LoginSession is a login where the user either launches the BankID app on the same device, see AutoStartLink, or
+// scans the animated QR code with another device, see AnimateQRCode, for the same order.
+//
+// The session collects the order and tracks the path the user took from the hint codes, which is a best-effort
+// heuristic. The hint code started is only reported for orders started with the autostart token, and only while the
+// launched app has not yet found a usable ID. An order that reaches userSign without it was either scanned or started
+// on the same device by an app that found the ID at once, it is taken to have been scanned if the QR code of the
+// order has been shown by AnimateQRCode and the path is left unknown otherwise. A same device login whose QR code
+// was also shown may thereby be reported as LoginPathOtherDevice.
+//
+// An order that fails to start, or expires before the user starts it, is replaced by a new order with the same
+// payload, up to the maximum number of restarts. A session is safe for concurrent use.
+type LoginSession struct {
+	client      BankID
+	payload     *payload.AuthenticationPayload
+	maxRestarts int
+
+	mutex    sync.Mutex
+	order    *Order
+	state    *OrderState
+	path     LoginPath
+	restarts int
+	// True once the QR code of the order has been sent to be shown
+	shown bool
+	// Closed and replaced when the order is replaced or its QR code is no longer scannable
+	changed chan struct{}
+}
+
+// Order returns the current order of the session.
+func (s *LoginSession) Order() *Order {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.order
+}
+
+// AutoStartLink returns the link that launches the BankID app on the device of the user for the current order, see
+// AutoStartLink.
+func (s *LoginSession) AutoStartLink(redirect string) string {
+	return AutoStartLink(s.Order().AutoStartToken, redirect)
+}
+
+// Path returns the way the user started the order.
+func (s *LoginSession) Path() LoginPath {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.path
+}
+
+// Restarts returns the number of times the session has started a new order.
+func (s *LoginSession) Restarts() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.restarts
+}
+
+// Collect collects the current order and returns the status of the session. An order that failed to start or expired
+// is replaced by a new order, unless the session has restarted the maximum number of times.
+func (s *LoginSession) Collect(ctx context.Context) (*LoginStatus, error) {
+	order := s.Order()
+
+	collected, err := s.client.Collect(ctx, &payload.CollectPayload{OrderRef: order.OrderRef})
+	if err != nil {
+		return nil, err // nolint:wrapcheck
+	}
+
+	status, restart, err := s.apply(order, collected)
+	if err != nil || !restart {
+		return status, err
+	}
+
+	return s.restart(ctx, order)
+}
+
+// AnimateQRCode sends the content of the animated QR code of the current order to the function every second, see
+// AnimateQRCode, following the session to the new order when it restarts.
+//
+// It returns nil once the user has started the order or the session is done, the error of the context if it is done
+// first and the error of the function if it fails.
+func (s *LoginSession) AnimateQRCode(ctx context.Context, send func(content string) error) error {
+	for {
+		s.mutex.Lock()
+		order, changed, scannable := s.order, s.changed, s.scannable()
+		s.mutex.Unlock()
+
+		if !scannable {
+			return nil
+		}
+
+		animation, cancel := context.WithCancel(ctx)
+
+		go func() {
+			select {
+			case <-changed:
+				cancel()
+			case <-animation.Done():
+			}
+		}()
+
+		err := AnimateQRCode(animation, s.client, order, func(content string) error {
+			s.mutex.Lock()
+			s.shown = s.shown || s.order == order
+			s.mutex.Unlock()
+
+			return send(content)
+		})
+
+		cancel()
+
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err() // nolint:wrapcheck
+		case errors.Is(err, context.Canceled):
+			// The session changed, it is animated again
+			continue
+		case err != nil:
+			return err
+		}
+
+		// The QR code has expired, the animation resumes if the session restarts
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err() // nolint:wrapcheck
+		}
+	}
+}
+
+// Cancel cancels the current order of the session.
+func (s *LoginSession) Cancel(ctx context.Context) error {
+	order := s.Order()
+
+	if _, err := s.client.Cancel(ctx, &payload.CancelPayload{OrderRef: order.OrderRef}); err != nil {
+		return fmt.Errorf("unable to cancel the login order. %w", err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.order == order {
+		_ = s.state.Cancel(time.Now())
+		s.notify()
+	}
+
+	return nil
+}
+
+// start starts the first order of the session.
+func (s *LoginSession) start(ctx context.Context) error {
+	started, err := s.client.Authenticate(ctx, s.payload)
+	if err != nil {
+		return fmt.Errorf("unable to start the login order. %w", err)
+	}
+
+	s.use(started)
+
+	return nil
+}
+
+// apply applies the collect response of the order and returns the status of the session, or true if the order is
+// to be replaced by a new order.
+func (s *LoginSession) apply(order *Order, collected *response.CollectResponse) (*LoginStatus, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.order != order {
+		// The session restarted while collecting, the response is of a replaced order
+		return s.status(nil), false, nil
+	}
+
+	scannable := s.scannable()
+
+	if err := s.state.Apply(collected, time.Now()); err != nil {
+		return nil, false, fmt.Errorf("unable to collect the login order. %w", err)
+	}
+
+	s.trackPath(collected.HintCode)
+
+	// The animation is notified once the order is replaced, rather than stopping with the failed order
+	if s.restartable(collected) {
+		return nil, true, nil
+	}
+
+	if scannable != s.scannable() {
+		s.notify()
+	}
+
+	if collected.IsComplete() {
+		return s.status(&collected.CompletionData), false, nil
+	}
+
+	return s.status(nil), false, nil
+}
+
+// restart replaces the order with a new order. The new order is started without locking the session, so that the
+// session can be read meanwhile, and is cancelled if the session was restarted by a concurrent collect.
+func (s *LoginSession) restart(ctx context.Context, order *Order) (*LoginStatus, error) {
+	started, err := s.client.Authenticate(ctx, s.payload)
+	if err != nil {
+		s.mutex.Lock()
+
+		if s.order == order {
+			// The failed order is kept, the animation stops
+			s.notify()
+		}
+
+		s.mutex.Unlock()
+
+		return nil, fmt.Errorf("unable to start the login order. %w", err)
+	}
+
+	s.mutex.Lock()
+
+	if s.order != order {
+		status := s.status(nil)
+		s.mutex.Unlock()
+
+		_, _ = s.client.Cancel(context.WithoutCancel(ctx), &payload.CancelPayload{OrderRef: started.OrderRef})
+
+		return status, nil
+	}
+
+	defer s.mutex.Unlock()
+
+	s.use(started)
+	s.restarts++
+	s.notify()
+
+	status := s.status(nil)
+	status.Restarted = true
+
+	return status, nil
+}
+
+// use makes the started order the order of the session, the session is expected to be locked unless it is being
+// created.
+func (s *LoginSession) use(started *response.AuthenticateResponse) {
+	s.order = NewAuthenticateOrder(started)
+	s.state = NewOrderState(s.order.OrderRef, s.order.StartedAt)
+	s.path = LoginPathUnknown
+	s.shown = false
+}
+
+// restartable returns true if the order failed before the user started it and the session may restart.
+func (s *LoginSession) restartable(collected *response.CollectResponse) bool {
+	if !collected.IsFailed() || s.restarts >= s.maxRestarts {
+		return false
+	}
+
+	switch response.HintCode(collected.HintCode) {
+	case response.HintCodeStartFailed, response.HintCodeExpiredTransaction:
+		return true
+	default:
+		return false
+	}
+}
+
+// trackPath records the path the user took from the hint code, the session is expected to be locked.
+func (s *LoginSession) trackPath(hintCode string) {
+	if s.path != LoginPathUnknown {
+		return
+	}
+
+	switch response.HintCode(hintCode) {
+	case response.HintCodeStarted:
+		s.path = LoginPathSameDevice
+	case response.HintCodeUserSign, response.HintCodeUserMrtd, response.HintCodeUserCallConfirm,
+		response.HintCodeProcessing:
+		// The user may also have launched the app on the same device, see LoginSession
+		if s.shown {
+			s.path = LoginPathOtherDevice
+		}
+	}
+}
+
+// scannable returns true if the QR code of the order may still be scanned, the session is expected to be locked.
+func (s *LoginSession) scannable() bool {
+	switch s.state.Phase() {
+	case PhaseCreated:
+		return true
+	case PhasePending:
+		return !isStarted(s.state.HintCode())
+	default:
+		return false
+	}
+}
+
+// notify wakes the goroutines waiting for the session to change, the session is expected to be locked.
+func (s *LoginSession) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// status returns the status of the session, the session is expected to be locked.
+func (s *LoginSession) status(completionData *response.CompletionData) *LoginStatus {
+	return &LoginStatus{
+		Order:          s.order,
+		Phase:          s.state.Phase(),
+		HintCode:       s.state.HintCode(),
+		Path:           s.path,
+		CompletionData: completionData,
+	}
+}
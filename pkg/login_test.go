@@ -0,0 +1,275 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/e-identification/bankid-go/pkg/payload"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAutoStartLink(t *testing.T) {
+	assert.Equal(t, "https://app.bankid.com/?autostarttoken=token&redirect=null", AutoStartLink("token", ""))
+	assert.Equal(t, "https://app.bankid.com/?autostarttoken=token&redirect=https%3A%2F%2Fexample.com%2F%3Fa%3Db",
+		AutoStartLink("token", "https://example.com/?a=b"))
+}
+
+func TestLoginSessionRestartsAndTracksPath(t *testing.T) {
+	collects := make(chan string, 4)
+	collects <- `{"status":"pending","hintCode":"outstandingTransaction"}`
+	collects <- `{"status":"failed","hintCode":"startFailed"}`
+	collects <- `{"status":"pending","hintCode":"userSign"}`
+	collects <- `{"status":"complete","completionData":{"user":{"personalNumber":"199001011234"}}}`
+
+	bankID, teardown := testBankID(loginHandler(t, collects))
+	defer teardown()
+
+	ctx := context.Background()
+
+	session, err := NewLoginOrchestrator(bankID).Start(ctx, &payload.AuthenticationPayload{EndUserIP: "192.168.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, "order-1", session.Order().OrderRef)
+	assert.Equal(t, "https://app.bankid.com/?autostarttoken=auto-1&redirect=null", session.AutoStartLink(""))
+
+	status, err := session.Collect(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, PhasePending, status.Phase)
+	assert.Equal(t, LoginPathUnknown, status.Path)
+
+	status, err = session.Collect(ctx)
+	assert.NoError(t, err)
+	assert.True(t, status.Restarted)
+	assert.Equal(t, "order-2", status.Order.OrderRef)
+	assert.Equal(t, PhaseCreated, status.Phase)
+	assert.Equal(t, 1, session.Restarts())
+
+	showQRCode(t, session)
+
+	status, err = session.Collect(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, LoginPathOtherDevice, status.Path)
+
+	status, err = session.Collect(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, PhaseComplete, status.Phase)
+	assert.Equal(t, "199001011234", status.CompletionData.User.PersonalNumber)
+	assert.Equal(t, LoginPathOtherDevice, session.Path())
+}
+
+func TestLoginSessionSameDeviceWithoutStarted(t *testing.T) {
+	collects := make(chan string, 2)
+	collects <- `{"status":"pending","hintCode":"outstandingTransaction"}`
+	collects <- `{"status":"pending","hintCode":"userSign"}`
+
+	bankID, teardown := testBankID(loginHandler(t, collects))
+	defer teardown()
+
+	ctx := context.Background()
+
+	session, err := NewLoginOrchestrator(bankID).Start(ctx, &payload.AuthenticationPayload{EndUserIP: "192.168.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The app launched on the same device found the ID at once, the QR code was never shown
+	for range 2 {
+		_, err = session.Collect(ctx)
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, LoginPathUnknown, session.Path())
+	assert.NoError(t, session.AnimateQRCode(ctx, func(string) error {
+		t.Error("started order was animated")
+
+		return nil
+	}))
+}
+
+func TestLoginSessionRestartDoesNotLockTheSession(t *testing.T) {
+	collects := make(chan string, 1)
+	collects <- `{"status":"failed","hintCode":"startFailed"}`
+
+	var authentications atomic.Int32
+
+	release := make(chan struct{})
+	handler := loginHandler(t, collects)
+
+	bankID, teardown := testBankID(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path == "/rp/v6.0/auth" && authentications.Add(1) > 1 {
+			<-release
+		}
+
+		handler(writer, request)
+	})
+	defer teardown()
+
+	ctx := context.Background()
+
+	session, err := NewLoginOrchestrator(bankID).Start(ctx, &payload.AuthenticationPayload{EndUserIP: "192.168.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := make(chan *LoginStatus)
+
+	go func() {
+		status, err := session.Collect(ctx)
+		assert.NoError(t, err)
+
+		restarted <- status
+	}()
+
+	assert.Eventually(t, func() bool { return authentications.Load() == 2 }, time.Second, time.Millisecond)
+
+	// The session is read while the new order is being started
+	assert.Equal(t, "order-1", session.Order().OrderRef)
+	assert.Equal(t, LoginPathUnknown, session.Path())
+
+	close(release)
+
+	status := <-restarted
+	assert.True(t, status.Restarted)
+	assert.Equal(t, "order-2", session.Order().OrderRef)
+}
+
+func TestLoginSessionMaxRestarts(t *testing.T) {
+	collects := make(chan string, 2)
+	collects <- `{"status":"failed","hintCode":"expiredTransaction"}`
+	collects <- `{"status":"failed","hintCode":"expiredTransaction"}`
+
+	bankID, teardown := testBankID(loginHandler(t, collects))
+	defer teardown()
+
+	ctx := context.Background()
+	orchestrator := NewLoginOrchestrator(bankID, WithMaxRestarts(1))
+
+	session, err := orchestrator.Start(ctx, &payload.AuthenticationPayload{EndUserIP: "192.168.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := session.Collect(ctx)
+	assert.NoError(t, err)
+	assert.True(t, status.Restarted)
+
+	status, err = session.Collect(ctx)
+	assert.NoError(t, err)
+	assert.False(t, status.Restarted)
+	assert.Equal(t, PhaseExpired, status.Phase)
+	assert.Equal(t, "order-2", status.Order.OrderRef)
+}
+
+func TestLoginSessionAnimateQRCode(t *testing.T) {
+	collects := make(chan string, 2)
+	collects <- `{"status":"failed","hintCode":"startFailed"}`
+	collects <- `{"status":"pending","hintCode":"started"}`
+
+	bankID, teardown := testBankID(loginHandler(t, collects))
+	defer teardown()
+
+	ctx := context.Background()
+
+	session, err := NewLoginOrchestrator(bankID).Start(ctx, &payload.AuthenticationPayload{EndUserIP: "192.168.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mutex    sync.Mutex
+		contents []string
+		done     = make(chan error)
+	)
+
+	go func() {
+		done <- session.AnimateQRCode(ctx, func(content string) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			contents = append(contents, content)
+
+			return nil
+		})
+	}()
+
+	animated := func(qrStartToken string) func() bool {
+		return func() bool {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			return len(contents) > 0 && strings.HasPrefix(contents[len(contents)-1], "bankid."+qrStartToken+".")
+		}
+	}
+
+	assert.Eventually(t, animated("qr-1"), time.Second, time.Millisecond)
+
+	// The animation follows the session to the new order
+	_, err = session.Collect(ctx)
+	assert.NoError(t, err)
+	assert.Eventually(t, animated("qr-2"), time.Second, time.Millisecond)
+
+	// The animation stops once the user has started the order
+	status, err := session.Collect(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, LoginPathSameDevice, status.Path)
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("animation did not stop")
+	}
+}
+
+// showQRCode shows the QR code of the current order of the session once.
+func showQRCode(t *testing.T, session *LoginSession) {
+	t.Helper()
+
+	shown := errors.New("shown")
+
+	assert.ErrorIs(t, session.AnimateQRCode(context.Background(), func(string) error { return shown }), shown)
+}
+
+// loginHandler starts orders with unique references and tokens, and answers each collect with the next response of
+// the channel for the latest order.
+func loginHandler(t *testing.T, collects <-chan string) http.HandlerFunc {
+	t.Helper()
+
+	var (
+		mutex  sync.Mutex
+		orders int
+	)
+
+	return func(writer http.ResponseWriter, request *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		switch request.URL.Path {
+		case "/rp/v6.0/auth":
+			orders++
+			body := fmt.Sprintf(`{"orderRef":"order-%[1]d","autoStartToken":"auto-%[1]d","qrStartToken":"qr-%[1]d",`+
+				`"qrStartSecret":"secret-%[1]d"}`, orders)
+			stringToResponseHandler(t, body)(writer, request)
+		case "/rp/v6.0/collect":
+			var collectPayload payload.CollectPayload
+			assert.NoError(t, json.NewDecoder(request.Body).Decode(&collectPayload))
+			assert.Equal(t, fmt.Sprintf("order-%d", orders), collectPayload.OrderRef)
+
+			body := strings.Replace(<-collects, "{", fmt.Sprintf(`{"orderRef":%q,`, collectPayload.OrderRef), 1)
+			stringToResponseHandler(t, body)(writer, request)
+		default:
+			t.Errorf("unexpected request to %s", request.URL.Path)
+		}
+	}
+}
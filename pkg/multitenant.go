@@ -0,0 +1,233 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/e-identification/bankid-go/pkg/configuration"
+	"github.com/e-identification/bankid-go/pkg/payload"
+	"github.com/e-identification/bankid-go/pkg/response"
+)
+
+// ErrNoTenant is returned by the MultiTenantClient when the context holds no tenant.
+var ErrNoTenant = errors.New("no tenant in context")
+
+// tenantContextKey is the context key of the tenant ID.
+type tenantContextKey struct{}
+
+// WithTenant returns a copy of the context holding the tenant ID used by the MultiTenantClient.
+func WithTenant(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenantID)
+}
+
+// TenantFromContext returns the tenant ID held by the context.
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenantID, ok := ctx.Value(tenantContextKey{}).(string)
+
+	return tenantID, ok && tenantID != ""
+}
+
+// ConfigurationProvider returns the configuration of a tenant.
+type ConfigurationProvider func(tenantID string) (*configuration.Configuration, error)
+
+// To ensure that MultiTenantClient implements the BankID interface.
+var _ BankID = (*MultiTenantClient)(nil)
+
+// tenantClient holds the lazily created client of a tenant.
+type tenantClient struct {
+	once   sync.Once
+	client *BankIDClient
+	err    error
+}
+
+// MultiTenantClient routes each call to the BankIDClient of the tenant held by the context, see WithTenant.
+//
+// The client of a tenant, and thereby its TLS transport, is created on first use from the configuration returned by
+// the ConfigurationProvider and is cached until evicted.
+type MultiTenantClient struct {
+	provider ConfigurationProvider
+	mutex    sync.Mutex
+	clients  map[string]*tenantClient
+}
+
+// NewMultiTenantClient returns a new instance of 'MultiTenantClient'.
+func NewMultiTenantClient(provider ConfigurationProvider) *MultiTenantClient {
+	return &MultiTenantClient{provider: provider, clients: map[string]*tenantClient{}}
+}
+
+// Client returns the client of the tenant, creating it if needed.
+func (m *MultiTenantClient) Client(tenantID string) (*BankIDClient, error) {
+	m.mutex.Lock()
+
+	entry, ok := m.clients[tenantID]
+	if !ok {
+		entry = &tenantClient{}
+		m.clients[tenantID] = entry
+	}
+
+	m.mutex.Unlock()
+
+	m.create(entry, tenantID)
+
+	if entry.err != nil {
+		// Forget the failure, allowing the client to be created on a later call
+		m.mutex.Lock()
+		if m.clients[tenantID] == entry {
+			delete(m.clients, tenantID)
+		}
+		m.mutex.Unlock()
+
+		return nil, entry.err
+	}
+
+	return entry.client, nil
+}
+
+// Evict removes the cached client of the tenant, which is recreated with a fresh configuration on next use. The
+// evicted client is closed, see BankIDClient.Close, the calls in flight on it are not affected.
+func (m *MultiTenantClient) Evict(tenantID string) {
+	m.mutex.Lock()
+	entry, ok := m.clients[tenantID]
+	delete(m.clients, tenantID)
+	m.mutex.Unlock()
+
+	if !ok {
+		return
+	}
+
+	// A client that is being created is waited for, so that it is closed too
+	m.create(entry, tenantID)
+
+	if entry.client != nil {
+		entry.client.Close()
+	}
+}
+
+// Authenticate - Initiates an authentication order using the client of the tenant in the context.
+//
+// See BankIDClient.Authenticate. It returns ErrNoTenant if the context holds no tenant.
+func (m *MultiTenantClient) Authenticate(
+	context context.Context,
+	payload *payload.AuthenticationPayload,
+) (*response.AuthenticateResponse, error) {
+	client, err := m.clientFor(context)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Authenticate(context, payload)
+}
+
+// PhoneAuthenticate - Initiates a phone authentication order using the client of the tenant in the context.
+//
+// See BankIDClient.PhoneAuthenticate. It returns ErrNoTenant if the context holds no tenant.
+func (m *MultiTenantClient) PhoneAuthenticate(
+	context context.Context,
+	payload *payload.PhoneAuthenticationPayload,
+) (*response.PhoneAuthenticateResponse, error) {
+	client, err := m.clientFor(context)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.PhoneAuthenticate(context, payload)
+}
+
+// Sign - Initiates a sign order using the client of the tenant in the context.
+//
+// See BankIDClient.Sign. It returns ErrNoTenant if the context holds no tenant.
+func (m *MultiTenantClient) Sign(
+	context context.Context,
+	payload *payload.SignPayload,
+) (*response.SignResponse, error) {
+	client, err := m.clientFor(context)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Sign(context, payload)
+}
+
+// PhoneSign - Initiates a phone sign order using the client of the tenant in the context.
+//
+// See BankIDClient.PhoneSign. It returns ErrNoTenant if the context holds no tenant.
+func (m *MultiTenantClient) PhoneSign(
+	context context.Context,
+	payload *payload.PhoneSignPayload,
+) (*response.PhoneSignResponse, error) {
+	client, err := m.clientFor(context)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.PhoneSign(context, payload)
+}
+
+// Collect - Collects the result of a sign or auth order using the client of the tenant in the context.
+//
+// See BankIDClient.Collect. It returns ErrNoTenant if the context holds no tenant.
+func (m *MultiTenantClient) Collect(
+	context context.Context,
+	payload *payload.CollectPayload,
+) (*response.CollectResponse, error) {
+	client, err := m.clientFor(context)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Collect(context, payload)
+}
+
+// Cancel - Cancels an ongoing sign or auth order using the client of the tenant in the context.
+//
+// See BankIDClient.Cancel. It returns ErrNoTenant if the context holds no tenant.
+func (m *MultiTenantClient) Cancel(
+	context context.Context,
+	payload *payload.CancelPayload,
+) (*response.CancelResponse, error) {
+	client, err := m.clientFor(context)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Cancel(context, payload)
+}
+
+// QRCodeContent - Generates the QR code content, which does not depend on the tenant.
+//
+// See BankIDClient.QRCodeContent.
+func (m *MultiTenantClient) QRCodeContent(qrStartToken, qrStartSecret string, seconds int) (string, error) {
+	return BankIDClient{}.QRCodeContent(qrStartToken, qrStartSecret, seconds)
+}
+
+func (m *MultiTenantClient) clientFor(ctx context.Context) (*BankIDClient, error) {
+	tenantID, ok := TenantFromContext(ctx)
+	if !ok {
+		return nil, ErrNoTenant
+	}
+
+	return m.Client(tenantID)
+}
+
+// create creates the client of the entry, unless it is or has been created.
+func (m *MultiTenantClient) create(entry *tenantClient, tenantID string) {
+	entry.once.Do(func() {
+		entry.client, entry.err = m.newClient(tenantID)
+	})
+}
+
+func (m *MultiTenantClient) newClient(tenantID string) (*BankIDClient, error) {
+	clientConfiguration, err := m.provider(tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve the configuration of tenant %q. %w", tenantID, err)
+	}
+
+	client, err := NewBankIDClient(clientConfiguration)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the client of tenant %q. %w", tenantID, err)
+	}
+
+	return client, nil
+}
@@ -0,0 +1,138 @@
+package pkg
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/e-identification/bankid-go/pkg/configuration"
+	"github.com/e-identification/bankid-go/pkg/fault"
+	"github.com/e-identification/bankid-go/pkg/payload"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultiTenantClientRoutesByTenant(t *testing.T) {
+	var (
+		mutex    sync.Mutex
+		provided = map[string]int{}
+	)
+
+	client := NewMultiTenantClient(func(tenantID string) (*configuration.Configuration, error) {
+		mutex.Lock()
+		provided[tenantID]++
+		mutex.Unlock()
+
+		return tenantConfiguration(`{"orderRef":"` + tenantID + `"}`), nil
+	})
+
+	var wait sync.WaitGroup
+
+	for range 10 {
+		for _, tenantID := range []string{"first", "second"} {
+			wait.Add(1)
+
+			go func() {
+				defer wait.Done()
+
+				result, err := client.Collect(WithTenant(context.Background(), tenantID), &payload.CollectPayload{})
+				if assert.NoError(t, err) {
+					assert.Equal(t, tenantID, result.OrderRef)
+				}
+			}()
+		}
+	}
+
+	wait.Wait()
+
+	assert.Equal(t, map[string]int{"first": 1, "second": 1}, provided)
+
+	client.Evict("first")
+
+	_, err := client.Collect(WithTenant(context.Background(), "first"), &payload.CollectPayload{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, provided["first"])
+}
+
+func TestMultiTenantClientErrors(t *testing.T) {
+	failure := errors.New("unknown tenant")
+	attempts := 0
+
+	client := NewMultiTenantClient(func(string) (*configuration.Configuration, error) {
+		attempts++
+		if attempts == 1 {
+			return nil, failure
+		}
+
+		return tenantConfiguration(`{}`), nil
+	})
+
+	_, err := client.Cancel(context.Background(), &payload.CancelPayload{})
+	assert.ErrorIs(t, err, ErrNoTenant)
+
+	_, err = client.Cancel(WithTenant(context.Background(), "first"), &payload.CancelPayload{})
+	assert.ErrorIs(t, err, failure)
+
+	_, err = client.Cancel(WithTenant(context.Background(), "first"), &payload.CancelPayload{})
+	assert.NoError(t, err)
+}
+
+func TestMultiTenantClientEvictClosesTheClient(t *testing.T) {
+	source := &listenerCountingSource{}
+
+	client := NewMultiTenantClient(func(string) (*configuration.Configuration, error) {
+		tenant := tenantConfiguration(`{}`)
+
+		certificate, err := tenant.Pkcs12.Certificate()
+		if err != nil {
+			return nil, err // nolint:wrapcheck
+		}
+
+		source.certificate = certificate
+		tenant.CertificateSource = source
+
+		return tenant, nil
+	})
+
+	if _, err := client.Client("first"); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, int32(1), source.listeners.Load())
+
+	client.Evict("first")
+	client.Evict("unknown")
+
+	assert.Zero(t, source.listeners.Load())
+}
+
+// tenantConfiguration returns a test configuration whose requests are answered with the body.
+func tenantConfiguration(body string) *configuration.Configuration {
+	return configuration.NewConfiguration(configuration.TestEnvironment,
+		&configuration.Pkcs12{Content: loadFile(getResourcePath("certificates/test.p12")), Password: "qwerty123"},
+		configuration.WithTransportWrapper(fault.Wrapper(fault.WithRule(fault.Rule{
+			Fault: fault.Status(http.StatusOK, "application/json", body),
+		}))))
+}
+
+// listenerCountingSource is a reloadable certificate source counting its reload listeners.
+type listenerCountingSource struct {
+	certificate *tls.Certificate
+	listeners   atomic.Int32
+}
+
+func (s *listenerCountingSource) ClientCertificate() (*tls.Certificate, error) {
+	return s.certificate, nil
+}
+
+func (s *listenerCountingSource) OnReload(func(*tls.Certificate)) func() {
+	s.listeners.Add(1)
+
+	return func() {
+		s.listeners.Add(-1)
+	}
+}
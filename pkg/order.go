@@ -0,0 +1,185 @@
+package pkg
+
+import (
+	"errors"
+	"time"
+
+	"github.com/e-identification/bankid-go/pkg/response"
+)
+
+var (
+	// ErrNoQRCode is returned when the QR code content is requested for an order that has no QR code.
+	ErrNoQRCode = errors.New("order has no qr code")
+	// ErrQRCodeExpired is returned when the QR code content is requested for an order whose QR code can no longer be
+	// scanned.
+	ErrQRCodeExpired = errors.New("qr code has expired")
+)
+
+const (
+	// QRCodeLifetime is the time from the start of an order during which its QR code can be scanned.
+	QRCodeLifetime = 30 * time.Second
+	// OrderLifetime is the time from the start of an order after which the BankID RP API expires it, unless it is
+	// complete or failed.
+	OrderLifetime = 3 * time.Minute
+)
+
+// OrderType is the type of the flow that started an order.
+type OrderType string
+
+const (
+	// OrderTypeAuthenticate is the type of an order started by Authenticate.
+	OrderTypeAuthenticate = OrderType("authenticate")
+	// OrderTypeSign is the type of an order started by Sign.
+	OrderTypeSign = OrderType("sign")
+	// OrderTypePhoneAuthenticate is the type of an order started by PhoneAuthenticate.
+	OrderTypePhoneAuthenticate = OrderType("phoneAuthenticate")
+	// OrderTypePhoneSign is the type of an order started by PhoneSign.
+	OrderTypePhoneSign = OrderType("phoneSign")
+)
+
+// Order holds the state of an order that is shared between the instances of a service, see OrderStore.
+type Order struct {
+	// Used to collect the status of the order.
+	OrderRef string `json:"orderRef"`
+	// The type of the flow that started the order.
+	Type OrderType `json:"type"`
+	// Used as reference to this order when the client is started automatically.
+	AutoStartToken string `json:"autoStartToken,omitempty"`
+	// Used to compute the animated QR code.
+	QrStartToken string `json:"qrStartToken,omitempty"`
+	// Used to compute the animated QR code.
+	QrStartSecret string `json:"qrStartSecret,omitempty"`
+	// The time the order was started, from which the seconds of the animated QR code are counted.
+	StartedAt time.Time `json:"startedAt"`
+	// The latest collected status of the order.
+	Status response.Status `json:"status"`
+	// The latest collected hint code of the order.
+	HintCode string `json:"hintCode,omitempty"`
+	// The time the status was last updated.
+	UpdatedAt time.Time `json:"updatedAt"`
+	// The idempotency key the order was started with, see IdempotentClient.
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+	// The hash of the payload the order was started with, see IdempotentClient.
+	PayloadHash string `json:"payloadHash,omitempty"`
+	// The session of the caller that started the order, see WithGuardSession.
+	GuardSession string `json:"guardSession,omitempty"`
+}
+
+// NewAuthenticateOrder returns the order started by Authenticate.
+func NewAuthenticateOrder(started *response.AuthenticateResponse) *Order {
+	return newQRCodeOrder(OrderTypeAuthenticate, started)
+}
+
+// NewSignOrder returns the order started by Sign.
+func NewSignOrder(started *response.SignResponse) *Order {
+	return newQRCodeOrder(OrderTypeSign, &started.AuthenticateResponse)
+}
+
+// NewPhoneAuthenticateOrder returns the order started by PhoneAuthenticate.
+func NewPhoneAuthenticateOrder(started *response.PhoneAuthenticateResponse) *Order {
+	return newOrder(OrderTypePhoneAuthenticate, started.OrderRef, time.Now())
+}
+
+// NewPhoneSignOrder returns the order started by PhoneSign.
+func NewPhoneSignOrder(started *response.PhoneSignResponse) *Order {
+	return newOrder(OrderTypePhoneSign, started.OrderRef, time.Now())
+}
+
+// IsPending returns true if the order is being processed.
+func (o *Order) IsPending() bool {
+	return o.Status == response.StatusPending
+}
+
+// HasQRCode returns true if the order was started with a QR code.
+func (o *Order) HasQRCode() bool {
+	return o.QrStartToken != "" && o.QrStartSecret != ""
+}
+
+// ExpiresAt returns the time the BankID RP API is expected to expire the order, which depends on the type of the
+// order and its latest hint code.
+//
+// An authenticate or sign order with a QR code expires with its QR code, see QRCodeExpiresAt, while the user has not
+// started it, that is while the hint code is outstandingTransaction or noClient. Other orders expire OrderLifetime
+// after they were started.
+func (o *Order) ExpiresAt() time.Time {
+	scannable := o.Type == OrderTypeAuthenticate || o.Type == OrderTypeSign
+
+	if scannable && o.HasQRCode() && o.IsPending() && !isStarted(o.HintCode) {
+		return o.QRCodeExpiresAt()
+	}
+
+	return o.StartedAt.Add(OrderLifetime)
+}
+
+// isStarted returns true if the hint code of a pending order tells that the user has started the order.
+func isStarted(hintCode string) bool {
+	switch response.HintCode(hintCode) {
+	case "", response.HintCodeOutstandingTransaction, response.HintCodeNoClient:
+		return false
+	default:
+		return true
+	}
+}
+
+// QRCodeExpiresAt returns the time the QR code of the order can no longer be scanned, the zero time for orders
+// started without a QR code.
+func (o *Order) QRCodeExpiresAt() time.Time {
+	if !o.HasQRCode() {
+		return time.Time{}
+	}
+
+	return o.StartedAt.Add(QRCodeLifetime)
+}
+
+// Remaining returns the time left at the given time until the order is expected to expire, zero once it has expired.
+func (o *Order) Remaining(now time.Time) time.Duration {
+	return max(o.ExpiresAt().Sub(now), 0)
+}
+
+// QRCodeContent returns the content of the animated QR code at the given time, counting the seconds from the start
+// of the order. It returns ErrNoQRCode for orders started without a QR code and ErrQRCodeExpired once the QR code can
+// no longer be scanned.
+func (o *Order) QRCodeContent(generator QRCodeContentGenerator, now time.Time) (string, error) {
+	if !o.HasQRCode() {
+		return "", ErrNoQRCode
+	}
+
+	if !now.Before(o.QRCodeExpiresAt()) {
+		return "", ErrQRCodeExpired
+	}
+
+	seconds := max(int(now.Sub(o.StartedAt).Seconds()), 0)
+
+	return generator.QRCodeContent(o.QrStartToken, o.QrStartSecret, seconds) // nolint:wrapcheck
+}
+
+// authenticateResponse returns the response that started the order.
+func (o *Order) authenticateResponse() *response.AuthenticateResponse {
+	return &response.AuthenticateResponse{
+		AutoStartToken: o.AutoStartToken,
+		OrderRef:       o.OrderRef,
+		QrStartToken:   o.QrStartToken,
+		QrStartSecret:  o.QrStartSecret,
+		TimeOfResponse: o.StartedAt,
+	}
+}
+
+func newQRCodeOrder(orderType OrderType, started *response.AuthenticateResponse) *Order {
+	startedAt := started.TimeOfResponse
+	if startedAt.IsZero() {
+		startedAt = time.Now()
+	}
+
+	order := newOrder(orderType, started.OrderRef, startedAt)
+	order.AutoStartToken = started.AutoStartToken
+	order.QrStartToken = started.QrStartToken
+	order.QrStartSecret = started.QrStartSecret
+
+	return order
+}
+
+func newOrder(orderType OrderType, orderRef string, startedAt time.Time) *Order {
+	return &Order{
+		OrderRef: orderRef, Type: orderType, StartedAt: startedAt, Status: response.StatusPending, UpdatedAt: startedAt,
+	}
+}
@@ -0,0 +1,261 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/e-identification/bankid-go/pkg/response"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrderStores(t *testing.T) {
+	fileStore, err := NewFileOrderStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	journal, err := OpenOrderJournal(t.TempDir() + "/orders.journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer journal.Close() // nolint:errcheck
+
+	stores := map[string]IdempotentOrderStore{"memory": NewMemoryOrderStore(), "file": fileStore, "journal": journal}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			testOrderStore(t, store)
+		})
+	}
+}
+
+func testOrderStore(t *testing.T, store IdempotentOrderStore) {
+	t.Helper()
+
+	ctx := context.Background()
+	started := time.Now().Add(-time.Minute).Round(0)
+
+	first := NewAuthenticateOrder(&response.AuthenticateResponse{
+		OrderRef: "../first", QrStartToken: "token", QrStartSecret: "secret", TimeOfResponse: started,
+	})
+	first.IdempotencyKey = "key"
+	second := NewPhoneSignOrder(&response.PhoneSignResponse{
+		PhoneAuthenticateResponse: response.PhoneAuthenticateResponse{OrderRef: "second"},
+	})
+
+	assert.NoError(t, store.Create(ctx, first))
+	assert.NoError(t, store.Create(ctx, second))
+	assert.ErrorIs(t, store.Create(ctx, first), ErrOrderExists)
+
+	// An order with the key of another order is not stored
+	reused := NewPhoneAuthenticateOrder(&response.PhoneAuthenticateResponse{OrderRef: "reused"})
+	reused.IdempotencyKey = "key"
+	assert.ErrorIs(t, store.Create(ctx, reused), ErrIdempotencyKeyExists)
+
+	_, err := store.Get(ctx, "reused")
+	assert.ErrorIs(t, err, ErrOrderNotFound)
+
+	stored, err := store.Get(ctx, "../first")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, "secret", stored.QrStartSecret)
+	assert.True(t, started.Equal(stored.StartedAt))
+	assert.Equal(t, OrderTypeAuthenticate, stored.Type)
+
+	indexed, err := store.GetByIdempotencyKey(ctx, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, stored, indexed)
+
+	assert.NoError(t, store.UpdateStatus(ctx, "second", response.StatusComplete, ""))
+	assert.NoError(t, store.UpdateStatus(ctx, "../first", response.StatusPending, "userSign"))
+	assert.ErrorIs(t, store.UpdateStatus(ctx, "missing", response.StatusFailed, ""), ErrOrderNotFound)
+
+	pending, err := store.ListPending(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Len(t, pending, 1)
+	assert.Equal(t, "userSign", pending[0].HintCode)
+
+	assert.NoError(t, store.Delete(ctx, "../first"))
+	assert.NoError(t, store.Delete(ctx, "../first"))
+
+	_, err = store.Get(ctx, "../first")
+	assert.ErrorIs(t, err, ErrOrderNotFound)
+
+	_, err = store.GetByIdempotencyKey(ctx, "key")
+	assert.ErrorIs(t, err, ErrOrderNotFound)
+
+	// Deleting the order releases its key
+	assert.NoError(t, store.Create(ctx, reused))
+}
+
+func TestOrderQRCodeContent(t *testing.T) {
+	now := time.Now()
+	order := NewAuthenticateOrder(&response.AuthenticateResponse{
+		OrderRef: "order", QrStartToken: "67df3917-fa0d-44e5-b327-edcc928297f8",
+		QrStartSecret: "d28db9a7-4cde-429e-a983-359be676944c", TimeOfResponse: now.Add(-3 * time.Second),
+	})
+
+	content, err := order.QRCodeContent(BankIDClient{}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, _ := BankIDClient{}.QRCodeContent(order.QrStartToken, order.QrStartSecret, 3)
+	assert.Equal(t, expected, content)
+
+	_, err = NewPhoneAuthenticateOrder(&response.PhoneAuthenticateResponse{OrderRef: "phone"}).
+		QRCodeContent(BankIDClient{}, now)
+	assert.ErrorIs(t, err, ErrNoQRCode)
+}
+
+func TestOrderExpiry(t *testing.T) {
+	now := time.Now()
+	order := NewAuthenticateOrder(&response.AuthenticateResponse{
+		OrderRef: "order", QrStartToken: "token", QrStartSecret: "secret", TimeOfResponse: now.Add(-time.Minute),
+	})
+
+	// The order expired with its QR code, as it was never scanned
+	assert.Equal(t, now.Add(-30*time.Second), order.QRCodeExpiresAt())
+	assert.Equal(t, order.QRCodeExpiresAt(), order.ExpiresAt())
+	assert.Zero(t, order.Remaining(now))
+
+	_, err := order.QRCodeContent(BankIDClient{}, now)
+	assert.ErrorIs(t, err, ErrQRCodeExpired)
+
+	// The order outlives its QR code once the user has started it
+	order.HintCode = string(response.HintCodeUserSign)
+	assert.Equal(t, now.Add(2*time.Minute), order.ExpiresAt())
+	assert.Equal(t, 2*time.Minute, order.Remaining(now))
+	assert.Zero(t, order.Remaining(now.Add(time.Hour)))
+
+	phoneOrder := NewPhoneAuthenticateOrder(&response.PhoneAuthenticateResponse{OrderRef: "phone"})
+	assert.True(t, phoneOrder.QRCodeExpiresAt().IsZero())
+	assert.False(t, phoneOrder.ExpiresAt().IsZero())
+}
+
+func TestOrderExpiryPerType(t *testing.T) {
+	now := time.Now()
+	started := response.AuthenticateResponse{
+		OrderRef: "order", QrStartToken: "token", QrStartSecret: "secret", TimeOfResponse: now,
+	}
+
+	tests := map[string]struct {
+		order    *Order
+		hintCode response.HintCode
+		expected time.Time
+	}{
+		"authenticate": {
+			order: NewAuthenticateOrder(&started), expected: now.Add(QRCodeLifetime),
+		},
+		"authenticate noClient": {
+			order: NewAuthenticateOrder(&started), hintCode: response.HintCodeNoClient, expected: now.Add(QRCodeLifetime),
+		},
+		"authenticate started": {
+			order: NewAuthenticateOrder(&started), hintCode: response.HintCodeStarted, expected: now.Add(OrderLifetime),
+		},
+		"authenticate without qr code": {
+			order:    NewAuthenticateOrder(&response.AuthenticateResponse{OrderRef: "order", TimeOfResponse: now}),
+			expected: now.Add(OrderLifetime),
+		},
+		"sign": {
+			order:    NewSignOrder(&response.SignResponse{AuthenticateResponse: started}),
+			hintCode: response.HintCodeOutstandingTransaction, expected: now.Add(QRCodeLifetime),
+		},
+		"sign userSign": {
+			order:    NewSignOrder(&response.SignResponse{AuthenticateResponse: started}),
+			hintCode: response.HintCodeUserSign, expected: now.Add(OrderLifetime),
+		},
+		"phone authenticate": {
+			order:    NewPhoneAuthenticateOrder(&response.PhoneAuthenticateResponse{OrderRef: "order"}),
+			hintCode: response.HintCodeOutstandingTransaction,
+		},
+		"phone sign": {
+			order: NewPhoneSignOrder(&response.PhoneSignResponse{
+				PhoneAuthenticateResponse: response.PhoneAuthenticateResponse{OrderRef: "order"},
+			}),
+			hintCode: response.HintCodeOutstandingTransaction,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tt.order.HintCode = string(tt.hintCode)
+
+			expected := tt.expected
+			if expected.IsZero() {
+				expected = tt.order.StartedAt.Add(OrderLifetime)
+			}
+
+			assert.Equal(t, expected, tt.order.ExpiresAt())
+		})
+	}
+}
+
+func TestAnimateQRCode(t *testing.T) {
+	order := NewAuthenticateOrder(&response.AuthenticateResponse{
+		OrderRef: "order", QrStartToken: "token", QrStartSecret: "secret",
+		TimeOfResponse: time.Now().Add(-QRCodeLifetime + 1500*time.Millisecond),
+	})
+
+	var contents []string
+
+	err := AnimateQRCode(context.Background(), BankIDClient{}, order, func(content string) error {
+		contents = append(contents, content)
+
+		return nil
+	})
+
+	// The animation stops once the QR code has expired
+	assert.NoError(t, err)
+	assert.Len(t, contents, 2)
+	assert.Regexp(t, `^bankid\.token\.28\.`, contents[0])
+	assert.Regexp(t, `^bankid\.token\.29\.`, contents[1])
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	order.StartedAt = time.Now()
+	assert.ErrorIs(t, AnimateQRCode(ctx, BankIDClient{}, order, func(string) error { return nil }), context.Canceled)
+
+	phoneOrder := NewPhoneAuthenticateOrder(&response.PhoneAuthenticateResponse{OrderRef: "phone"})
+	assert.ErrorIs(t, AnimateQRCode(context.Background(), BankIDClient{}, phoneOrder, nil), ErrNoQRCode)
+}
+
+func TestOrderHandler(t *testing.T) {
+	startedAt := time.Now().UTC()
+
+	store := NewMemoryOrderStore()
+	_ = store.Create(context.Background(), NewAuthenticateOrder(&response.AuthenticateResponse{
+		OrderRef: "order", QrStartToken: "token", QrStartSecret: "secret", TimeOfResponse: startedAt,
+	}))
+
+	handler := BankIDClient{}.OrderHandler(store)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/order?orderRef=order", nil))
+
+	var body map[string]string
+
+	_ = json.NewDecoder(recorder.Body).Decode(&body)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "pending", body["status"])
+	assert.Equal(t, startedAt.Add(QRCodeLifetime).Format(time.RFC3339Nano), body["expiresAt"])
+	assert.Regexp(t, `^bankid\.token\.\d+\.[0-9a-f]{64}$`, body["qrCode"])
+	assert.NotContains(t, body, "qrStartSecret")
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/order?orderRef=missing", nil))
+
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
@@ -0,0 +1,53 @@
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/e-identification/bankid-go/pkg/response"
+)
+
+// OrderHandler returns a http.Handler reporting the state of the order given by the orderRef query parameter as
+// JSON, including the time the order is expected to expire and the current content of the animated QR code while the
+// order is pending and the QR code can be scanned.
+//
+// The state is read from the store, which makes the handler answer for orders started by any instance of a service.
+// The handler responds with 404 Not Found for unknown orders. The QR start secret is never exposed.
+func (b BankIDClient) OrderHandler(store OrderStore) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		order, err := store.Get(request.Context(), request.URL.Query().Get("orderRef"))
+
+		switch {
+		case errors.Is(err, ErrOrderNotFound):
+			http.Error(writer, "order not found", http.StatusNotFound)
+
+			return
+		case err != nil:
+			http.Error(writer, "unable to read the order", http.StatusInternalServerError)
+
+			return
+		}
+
+		body := struct {
+			OrderRef  string          `json:"orderRef"`
+			Status    response.Status `json:"status"`
+			HintCode  string          `json:"hintCode,omitempty"`
+			ExpiresAt time.Time       `json:"expiresAt"`
+			QRCode    string          `json:"qrCode,omitempty"`
+		}{
+			OrderRef: order.OrderRef, Status: order.Status, HintCode: order.HintCode, ExpiresAt: order.ExpiresAt(),
+		}
+
+		if order.IsPending() {
+			// Orders without a QR code, or whose QR code has expired, are reported without one
+			body.QRCode, _ = order.QRCodeContent(b, time.Now())
+		}
+
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Header().Set("Cache-Control", "no-store")
+
+		_ = json.NewEncoder(writer).Encode(body)
+	})
+}
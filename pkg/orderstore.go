@@ -0,0 +1,454 @@
+package pkg
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/e-identification/bankid-go/pkg/response"
+)
+
+var (
+	// ErrOrderNotFound is returned by an OrderStore when the order does not exist.
+	ErrOrderNotFound = errors.New("order not found")
+	// ErrOrderExists is returned by an OrderStore when an order with the same orderRef already exists.
+	ErrOrderExists = errors.New("order already exists")
+	// ErrIdempotencyKeyExists is returned by an IdempotentOrderStore when an order with the same idempotency key already
+	// exists.
+	ErrIdempotencyKeyExists = errors.New("idempotency key already exists")
+)
+
+// OrderStore stores the state of the orders, allowing any instance of a service to collect an order or to animate its
+// QR code regardless of the instance that started it.
+type OrderStore interface {
+	// Create stores a new order. It returns ErrOrderExists if an order with the same orderRef already exists.
+	Create(ctx context.Context, order *Order) error
+	// Get returns the order. It returns ErrOrderNotFound if the order does not exist.
+	Get(ctx context.Context, orderRef string) (*Order, error)
+	// UpdateStatus updates the status and hint code of the order. It returns ErrOrderNotFound if the order does not
+	// exist.
+	UpdateStatus(ctx context.Context, orderRef string, status response.Status, hintCode string) error
+	// Delete removes the order, deleting an order that does not exist is not an error.
+	Delete(ctx context.Context, orderRef string) error
+	// ListPending returns the orders that are pending, ordered by the time they were started.
+	ListPending(ctx context.Context) ([]*Order, error)
+}
+
+// IdempotentOrderStore is an OrderStore that finds the orders by the idempotency key they were started with, see
+// IdempotentClient.
+//
+// Create returns ErrIdempotencyKeyExists if an order with the same idempotency key already exists. The check and the
+// creation are atomic, so that of the orders created concurrently with a key only one is stored. Deleting the order
+// releases its key.
+type IdempotentOrderStore interface {
+	OrderStore
+	// GetByIdempotencyKey returns the order created with the idempotency key. It returns ErrOrderNotFound if no order
+	// with the key exists.
+	GetByIdempotencyKey(ctx context.Context, idempotencyKey string) (*Order, error)
+}
+
+// To ensure that the stores implement the IdempotentOrderStore interface.
+var (
+	_ IdempotentOrderStore = (*MemoryOrderStore)(nil)
+	_ IdempotentOrderStore = (*FileOrderStore)(nil)
+)
+
+// MemoryOrderStore is an OrderStore holding the orders in memory, suitable for a single instance and for tests.
+type MemoryOrderStore struct {
+	mutex  sync.RWMutex
+	orders map[string]Order
+	keys   map[string]string
+}
+
+// NewMemoryOrderStore returns a new instance of 'MemoryOrderStore'.
+func NewMemoryOrderStore() *MemoryOrderStore {
+	return &MemoryOrderStore{orders: map[string]Order{}, keys: map[string]string{}}
+}
+
+// Create stores a new order.
+func (m *MemoryOrderStore) Create(_ context.Context, order *Order) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.orders[order.OrderRef]; ok {
+		return fmt.Errorf("%w. %s", ErrOrderExists, order.OrderRef)
+	}
+
+	if _, ok := m.keys[order.IdempotencyKey]; ok && order.IdempotencyKey != "" {
+		return fmt.Errorf("%w. %s", ErrIdempotencyKeyExists, order.IdempotencyKey)
+	}
+
+	m.put(order)
+
+	return nil
+}
+
+// put stores the order, replacing the order with the same orderRef and indexing it by its idempotency key, the store
+// is expected to be locked.
+func (m *MemoryOrderStore) put(order *Order) {
+	if previous, ok := m.orders[order.OrderRef]; ok && m.keys[previous.IdempotencyKey] == order.OrderRef {
+		delete(m.keys, previous.IdempotencyKey)
+	}
+
+	m.orders[order.OrderRef] = *order
+
+	if order.IdempotencyKey != "" {
+		m.keys[order.IdempotencyKey] = order.OrderRef
+	}
+}
+
+// Get returns the order.
+func (m *MemoryOrderStore) Get(_ context.Context, orderRef string) (*Order, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	order, ok := m.orders[orderRef]
+	if !ok {
+		return nil, fmt.Errorf("%w. %s", ErrOrderNotFound, orderRef)
+	}
+
+	return &order, nil
+}
+
+// UpdateStatus updates the status and hint code of the order.
+func (m *MemoryOrderStore) UpdateStatus(_ context.Context, orderRef string, status response.Status,
+	hintCode string,
+) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	order, ok := m.orders[orderRef]
+	if !ok {
+		return fmt.Errorf("%w. %s", ErrOrderNotFound, orderRef)
+	}
+
+	order.Status, order.HintCode, order.UpdatedAt = status, hintCode, time.Now()
+	m.orders[orderRef] = order
+
+	return nil
+}
+
+// Delete removes the order.
+func (m *MemoryOrderStore) Delete(_ context.Context, orderRef string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if order, ok := m.orders[orderRef]; ok && m.keys[order.IdempotencyKey] == orderRef {
+		delete(m.keys, order.IdempotencyKey)
+	}
+
+	delete(m.orders, orderRef)
+
+	return nil
+}
+
+// GetByIdempotencyKey returns the order created with the idempotency key.
+func (m *MemoryOrderStore) GetByIdempotencyKey(ctx context.Context, idempotencyKey string) (*Order, error) {
+	m.mutex.RLock()
+	orderRef, ok := m.keys[idempotencyKey]
+	m.mutex.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w. %s", ErrOrderNotFound, idempotencyKey)
+	}
+
+	return m.Get(ctx, orderRef)
+}
+
+// ListPending returns the orders that are pending.
+func (m *MemoryOrderStore) ListPending(_ context.Context) ([]*Order, error) {
+	return m.filter((*Order).IsPending), nil
+}
+
+// filter returns the orders matching the function, ordered as by ListPending.
+func (m *MemoryOrderStore) filter(matches func(*Order) bool) []*Order {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var matching []*Order
+
+	for _, order := range m.orders {
+		if matches(&order) {
+			matching = append(matching, &order)
+		}
+	}
+
+	sortOrders(matching)
+
+	return matching
+}
+
+// FileOrderStore is an OrderStore holding each order in a JSON file of a directory, which may be shared between the
+// instances of a service.
+//
+// The files are replaced atomically, concurrent updates of the same order are resolved by the last writer. The files
+// hold the QR start secret and are created readable by the owner only. The orders started with an idempotency key are
+// indexed by a file of the key holding the orderRef, which is linked in place and so is created by only one of the
+// instances.
+type FileOrderStore struct {
+	directory string
+	mutex     sync.Mutex
+}
+
+// NewFileOrderStore returns a new instance of 'FileOrderStore' storing the orders in the directory, which is created
+// if it does not exist.
+func NewFileOrderStore(directory string) (*FileOrderStore, error) {
+	if err := os.MkdirAll(directory, 0o700); err != nil {
+		return nil, fmt.Errorf("unable to create the order directory. %w", err)
+	}
+
+	return &FileOrderStore{directory: directory}, nil
+}
+
+// Create stores a new order.
+func (f *FileOrderStore) Create(_ context.Context, order *Order) error {
+	content, err := json.Marshal(order)
+	if err != nil {
+		return fmt.Errorf("unable to encode the order. %w", err)
+	}
+
+	// The order is written to a temporary file that is linked in place, which fails if the order already exists
+	temporary, err := f.writeTemporary(content)
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(temporary) // nolint:errcheck
+
+	if err := os.Link(temporary, f.path(order.OrderRef)); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("%w. %s", ErrOrderExists, order.OrderRef)
+		}
+
+		return fmt.Errorf("unable to store the order. %w", err)
+	}
+
+	if order.IdempotencyKey == "" {
+		return nil
+	}
+
+	if err := f.index(order); err != nil {
+		_ = os.Remove(f.path(order.OrderRef))
+
+		return err
+	}
+
+	return nil
+}
+
+// index links the index file of the idempotency key of the order in place, which fails if the key is indexed. An index
+// left behind by an order that no longer exists is replaced.
+func (f *FileOrderStore) index(order *Order) error {
+	index, err := f.writeTemporary([]byte(order.OrderRef))
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(index) // nolint:errcheck
+
+	keyPath := f.keyPath(order.IdempotencyKey)
+
+	err = os.Link(index, keyPath)
+	if errors.Is(err, fs.ErrExist) && f.isStale(keyPath) {
+		_ = os.Remove(keyPath)
+
+		err = os.Link(index, keyPath)
+	}
+
+	switch {
+	case errors.Is(err, fs.ErrExist):
+		return fmt.Errorf("%w. %s", ErrIdempotencyKeyExists, order.IdempotencyKey)
+	case err != nil:
+		return fmt.Errorf("unable to index the order. %w", err)
+	}
+
+	return nil
+}
+
+// isStale returns whether the index file refers to an order that no longer exists.
+func (f *FileOrderStore) isStale(keyPath string) bool {
+	orderRef, err := os.ReadFile(keyPath) // #nosec G304
+	if err != nil {
+		return false
+	}
+
+	_, err = os.Stat(f.path(string(orderRef)))
+
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+// Get returns the order.
+func (f *FileOrderStore) Get(_ context.Context, orderRef string) (*Order, error) {
+	return f.read(f.path(orderRef))
+}
+
+// UpdateStatus updates the status and hint code of the order.
+func (f *FileOrderStore) UpdateStatus(_ context.Context, orderRef string, status response.Status,
+	hintCode string,
+) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	order, err := f.read(f.path(orderRef))
+	if err != nil {
+		return err
+	}
+
+	order.Status, order.HintCode, order.UpdatedAt = status, hintCode, time.Now()
+
+	content, err := json.Marshal(order)
+	if err != nil {
+		return fmt.Errorf("unable to encode the order. %w", err)
+	}
+
+	temporary, err := f.writeTemporary(content)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(temporary, f.path(orderRef)); err != nil {
+		_ = os.Remove(temporary)
+
+		return fmt.Errorf("unable to store the order. %w", err)
+	}
+
+	return nil
+}
+
+// Delete removes the order.
+func (f *FileOrderStore) Delete(_ context.Context, orderRef string) error {
+	order, err := f.read(f.path(orderRef))
+	if errors.Is(err, ErrOrderNotFound) {
+		return nil
+	}
+
+	if err := os.Remove(f.path(orderRef)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("unable to delete the order. %w", err)
+	}
+
+	// The index is kept if the key has been reused by a later order
+	if order != nil && order.IdempotencyKey != "" {
+		keyPath := f.keyPath(order.IdempotencyKey)
+
+		indexed, err := os.ReadFile(keyPath) // #nosec G304
+		if err == nil && string(indexed) == orderRef {
+			_ = os.Remove(keyPath)
+		}
+	}
+
+	return nil
+}
+
+// GetByIdempotencyKey returns the order created with the idempotency key.
+func (f *FileOrderStore) GetByIdempotencyKey(_ context.Context, idempotencyKey string) (*Order, error) {
+	orderRef, err := os.ReadFile(f.keyPath(idempotencyKey)) // #nosec G304
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w. %s", ErrOrderNotFound, idempotencyKey)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the order index. %w", err)
+	}
+
+	return f.read(f.path(string(orderRef)))
+}
+
+// ListPending returns the orders that are pending.
+func (f *FileOrderStore) ListPending(_ context.Context) ([]*Order, error) {
+	entries, err := os.ReadDir(f.directory)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the orders. %w", err)
+	}
+
+	var pending []*Order
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), orderFileSuffix) {
+			continue
+		}
+
+		order, err := f.read(filepath.Join(f.directory, entry.Name()))
+
+		switch {
+		case errors.Is(err, ErrOrderNotFound):
+			// Deleted since the directory was read
+		case err != nil:
+			return nil, err
+		case order.IsPending():
+			pending = append(pending, order)
+		}
+	}
+
+	sortOrders(pending)
+
+	return pending, nil
+}
+
+// The suffixes of the order files and the index files, temporary files have neither.
+const (
+	orderFileSuffix = ".json"
+	keyFileSuffix   = ".key"
+)
+
+// path returns the path of the file of the order. The orderRef is encoded, as it is not trusted to be a file name.
+func (f *FileOrderStore) path(orderRef string) string {
+	return filepath.Join(f.directory, base64.RawURLEncoding.EncodeToString([]byte(orderRef))+orderFileSuffix)
+}
+
+// keyPath returns the path of the index file of the idempotency key.
+func (f *FileOrderStore) keyPath(idempotencyKey string) string {
+	return filepath.Join(f.directory, base64.RawURLEncoding.EncodeToString([]byte(idempotencyKey))+keyFileSuffix)
+}
+
+func (f *FileOrderStore) read(path string) (*Order, error) {
+	content, err := os.ReadFile(path) // #nosec G304
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w. %s", ErrOrderNotFound, filepath.Base(path))
+		}
+
+		return nil, fmt.Errorf("unable to read the order. %w", err)
+	}
+
+	order := &Order{}
+	if err := json.Unmarshal(content, order); err != nil {
+		return nil, fmt.Errorf("unable to decode the order %s. %w", filepath.Base(path), err)
+	}
+
+	return order, nil
+}
+
+func (f *FileOrderStore) writeTemporary(content []byte) (string, error) {
+	file, err := os.CreateTemp(f.directory, ".order-*")
+	if err != nil {
+		return "", fmt.Errorf("unable to store the order. %w", err)
+	}
+
+	_, err = file.Write(content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		_ = os.Remove(file.Name())
+
+		return "", fmt.Errorf("unable to store the order. %w", err)
+	}
+
+	return file.Name(), nil
+}
+
+func sortOrders(orders []*Order) {
+	slices.SortFunc(orders, func(a, b *Order) int {
+		return a.StartedAt.Compare(b.StartedAt)
+	})
+}
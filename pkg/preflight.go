@@ -0,0 +1,234 @@
+package pkg
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/e-identification/bankid-go/pkg/configuration"
+	"github.com/e-identification/bankid-go/pkg/payload"
+	"github.com/e-identification/bankid-go/pkg/response"
+)
+
+// probeOrderRef is the orderRef used when probing the BankID RP API, no order will ever have this reference.
+const probeOrderRef = "00000000-0000-0000-0000-000000000000"
+
+// clientCertificateIssuers maps the common names of the known issuers of client certificates to the root URL of the
+// environment their certificates are issued for.
+var clientCertificateIssuers = map[string]string{
+	"Testbank A RP CA v1 for BankID Test": configuration.TestEnvironment.RootURL,
+}
+
+// The names of the preflight checks.
+const (
+	PreflightCheckCertificate   = "certificate"
+	PreflightCheckEnvironment   = "environment"
+	PreflightCheckHandshake     = "handshake"
+	PreflightCheckAuthorization = "authorization"
+)
+
+// PreflightCheck holds the outcome of a single preflight check.
+type PreflightCheck struct {
+	// The name of the check.
+	Name string
+	// The error that made the check fail, nil if the check passed or was skipped.
+	Err error
+	// True if the check could not be performed.
+	Skipped bool
+	// The time the check took.
+	Duration time.Duration
+}
+
+// Passed returns true if the check passed.
+func (c PreflightCheck) Passed() bool {
+	return c.Err == nil && !c.Skipped
+}
+
+// PreflightReport holds the outcome of the preflight checks.
+type PreflightReport struct {
+	// The base URL of the environment that was checked.
+	BaseURL string
+	// The metadata of the client certificate, nil if it could not be loaded.
+	Certificate *configuration.CertificateInfo
+	// The outcome of each check, in the order they were performed.
+	Checks []PreflightCheck
+}
+
+// OK returns true if no check failed.
+func (r *PreflightReport) OK() bool {
+	return r.Err() == nil
+}
+
+// Err returns the errors of the failed checks joined together, or nil if no check failed.
+func (r *PreflightReport) Err() error {
+	var errs []error
+
+	for _, check := range r.Checks {
+		if check.Err != nil {
+			errs = append(errs, fmt.Errorf("%s check failed. %w", check.Name, check.Err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Check returns the check of the given name, such as PreflightCheckEnvironment, and false if there is none.
+func (r *PreflightReport) Check(name string) (PreflightCheck, bool) {
+	for _, check := range r.Checks {
+		if check.Name == name {
+			return check, true
+		}
+	}
+
+	return PreflightCheck{}, false
+}
+
+// probeResult holds the outcome of a probe of the BankID RP API.
+type probeResult struct {
+	// The error of the TLS handshake, if a new connection was established.
+	handshakeErr error
+	// True if a new connection, and thereby a TLS handshake, was made.
+	handshake bool
+	// True if an idle connection was reused.
+	reused bool
+	// True if the RP API responded.
+	responded bool
+	// The time the handshake took.
+	handshakeDuration time.Duration
+	// The error of the call, nil if the RP API answered as expected.
+	err error
+	// The time the call took.
+	latency time.Duration
+}
+
+// Preflight checks that the client is correctly set up against its environment.
+//
+// The client certificate is checked for validity and, for the test and production environments, that it is issued
+// for the selected environment. The environment of the certificate is known from the common name of its issuer, the
+// check is skipped for certificates of other issuers. An mTLS handshake is performed against the base URL and a collect of a non-existing
+// order is made to confirm that the RP is authorized to use the BankID RP API.
+func (b BankIDClient) Preflight(ctx context.Context) *PreflightReport {
+	report := &PreflightReport{BaseURL: b.configuration.Environment.BaseURL}
+
+	started := time.Now()
+	certificateCheck := PreflightCheck{Name: PreflightCheckCertificate}
+	environmentCheck := PreflightCheck{Name: PreflightCheckEnvironment}
+
+	leaf, err := b.leafCertificate()
+	if err != nil {
+		certificateCheck.Err = err
+		environmentCheck.Skipped = true
+	} else {
+		report.Certificate = configuration.NewCertificateInfo(leaf)
+		certificateCheck.Err = report.Certificate.Validate(time.Now())
+		environmentCheck.Skipped, environmentCheck.Err = b.checkEnvironment(leaf)
+	}
+
+	certificateCheck.Duration = time.Since(started)
+	report.Checks = append(report.Checks, certificateCheck, environmentCheck)
+
+	result := b.probe(ctx)
+
+	handshakeCheck := PreflightCheck{
+		Name: PreflightCheckHandshake, Err: result.handshakeErr, Duration: result.handshakeDuration,
+	}
+	authorizationCheck := PreflightCheck{Name: PreflightCheckAuthorization, Err: result.err, Duration: result.latency}
+
+	if result.handshakeErr != nil {
+		authorizationCheck.Err, authorizationCheck.Skipped = nil, true
+	}
+
+	report.Checks = append(report.Checks, handshakeCheck, authorizationCheck)
+
+	return report
+}
+
+// checkEnvironment checks that a certificate of a known issuer is used against the environment it is issued for. The
+// check is skipped for custom environments and for certificates of unknown issuers.
+func (b BankIDClient) checkEnvironment(certificate *x509.Certificate) (bool, error) {
+	rootURL := b.configuration.Environment.RootURL
+	if rootURL != configuration.TestEnvironment.RootURL && rootURL != configuration.ProductionEnvironment.RootURL {
+		return true, nil
+	}
+
+	issuedFor, ok := clientCertificateIssuers[certificate.Issuer.CommonName]
+	if !ok {
+		return true, nil
+	}
+
+	if issuedFor != rootURL {
+		return false, fmt.Errorf("%w. a certificate issued by %s is used against %s", ErrEnvironmentMismatch,
+			certificate.Issuer.CommonName, rootURL)
+	}
+
+	return false, nil
+}
+
+// probe collects a non-existing order, which the BankID RP API answers with invalidParameters if the RP is
+// authorized, and traces the TLS handshake of the call.
+func (b BankIDClient) probe(ctx context.Context) probeResult {
+	var (
+		result         probeResult
+		handshakeStart time.Time
+		// The trace hooks may be invoked from the goroutine dialing the connection.
+		mutex sync.Mutex
+	)
+
+	trace := &httptrace.ClientTrace{
+		TLSHandshakeStart: func() {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			handshakeStart = time.Now()
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			result.handshake = true
+			result.handshakeErr = err
+			result.handshakeDuration = time.Since(handshakeStart)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			result.reused = info.Reused
+		},
+		GotFirstResponseByte: func() {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			result.responded = true
+		},
+	}
+
+	started := time.Now()
+	_, err := b.Collect(httptrace.WithClientTrace(ctx, trace), &payload.CollectPayload{OrderRef: probeOrderRef})
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	result.latency = time.Since(started)
+
+	var apiError *APIError
+
+	switch {
+	case err == nil:
+	case errors.As(err, &apiError) && apiError.ErrorCode == string(response.ErrorInvalidParameters):
+	default:
+		result.err = err
+	}
+
+	// A connection that could not be established at all fails the handshake as well.
+	if result.handshakeErr == nil && !result.responded && !result.reused && result.err != nil {
+		result.handshakeErr = result.err
+	}
+
+	return result
+}
@@ -0,0 +1,117 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/e-identification/bankid-go/pkg/payload"
+	"github.com/e-identification/bankid-go/pkg/response"
+)
+
+// ResumeAction decides what Resume does with an order that was pending when the process stopped.
+type ResumeAction string
+
+const (
+	// ResumeCollect keeps the order pending, to be collected again, see collect.Scheduler.SyncStore.
+	ResumeCollect = ResumeAction("collect")
+	// ResumeCancel cancels the order, which frees the user from the order that would otherwise make a new order fail
+	// with alreadyInProgress.
+	ResumeCancel = ResumeAction("cancel")
+	// ResumeForget removes the order from the store without contacting the BankID RP API.
+	ResumeForget = ResumeAction("forget")
+)
+
+// ResumePolicy decides what Resume does with the orders that were pending when the process stopped.
+type ResumePolicy struct {
+	// The action for each type of order, types that are not listed use Default.
+	Actions map[OrderType]ResumeAction
+	// The action for the types of order that are not listed in Actions, defaults to ResumeCollect.
+	Default ResumeAction
+	// The orders started longer ago are forgotten, as the BankID RP API has expired them. Zero means no limit.
+	MaxAge time.Duration
+}
+
+// action returns the action for the order at the given time.
+func (p ResumePolicy) action(order *Order, now time.Time) ResumeAction {
+	if p.MaxAge > 0 && now.Sub(order.StartedAt) > p.MaxAge {
+		return ResumeForget
+	}
+
+	if action, ok := p.Actions[order.Type]; ok {
+		return action
+	}
+
+	if p.Default == "" {
+		return ResumeCollect
+	}
+
+	return p.Default
+}
+
+// ResumeReport holds the outcome of Resume.
+type ResumeReport struct {
+	// The orders that are kept pending, to be collected again.
+	Collect []*Order
+	// The references of the orders that were cancelled.
+	Cancelled []string
+	// The references of the orders that were forgotten.
+	Forgotten []string
+}
+
+// Resume handles the orders left pending in the store by a previous run of the process, such as the orders of an
+// OrderJournal after a restart, according to the policy.
+//
+// Cancelled and forgotten orders are deleted from the store, an order the BankID RP API no longer knows is considered
+// cancelled. The orders that could not be cancelled are kept in the store and their errors are returned joined
+// together, alongside the report.
+func (b BankIDClient) Resume(ctx context.Context, store OrderStore, policy ResumePolicy) (*ResumeReport, error) {
+	orders, err := store.ListPending(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the pending orders. %w", err)
+	}
+
+	var (
+		report = &ResumeReport{}
+		errs   []error
+		now    = time.Now()
+	)
+
+	for _, order := range orders {
+		switch policy.action(order, now) {
+		case ResumeCancel:
+			if err := b.cancelOrphan(ctx, order.OrderRef); err != nil {
+				errs = append(errs, fmt.Errorf("unable to cancel order %s. %w", order.OrderRef, err))
+
+				continue
+			}
+
+			report.Cancelled = append(report.Cancelled, order.OrderRef)
+		case ResumeForget:
+			report.Forgotten = append(report.Forgotten, order.OrderRef)
+		default:
+			report.Collect = append(report.Collect, order)
+
+			continue
+		}
+
+		if err := store.Delete(ctx, order.OrderRef); err != nil {
+			errs = append(errs, fmt.Errorf("unable to delete order %s. %w", order.OrderRef, err))
+		}
+	}
+
+	return report, errors.Join(errs...)
+}
+
+// cancelOrphan cancels the order, an order that is unknown to the BankID RP API is already gone.
+func (b BankIDClient) cancelOrphan(ctx context.Context, orderRef string) error {
+	_, err := b.Cancel(ctx, &payload.CancelPayload{OrderRef: orderRef})
+
+	var apiError *APIError
+	if errors.As(err, &apiError) && apiError.ErrorCode == string(response.ErrorInvalidParameters) {
+		return nil
+	}
+
+	return err
+}
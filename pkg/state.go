@@ -0,0 +1,265 @@
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/e-identification/bankid-go/pkg/response"
+)
+
+var (
+	// ErrInvalidTransition is returned by OrderState when a transition is not allowed from the phase of the order.
+	ErrInvalidTransition = errors.New("invalid order state transition")
+	// ErrOrderRegression is returned by OrderState when a collect response reports an order that has gone back to an
+	// earlier phase, which the BankID RP API never does.
+	ErrOrderRegression = errors.New("order state regressed")
+)
+
+// OrderPhase is a phase of the life cycle of an order, see OrderState.
+type OrderPhase string
+
+const (
+	// PhaseCreated is the phase of an order that has been started but not yet collected.
+	PhaseCreated = OrderPhase("created")
+	// PhasePending is the phase of an order that is being processed, the hint code describes the progress.
+	PhasePending = OrderPhase("pending")
+	// PhaseComplete is the phase of an order that is complete.
+	PhaseComplete = OrderPhase("complete")
+	// PhaseFailed is the phase of an order that has failed, the hint code describes the error.
+	PhaseFailed = OrderPhase("failed")
+	// PhaseCancelled is the phase of an order that was cancelled by the RP or the user, or by a new order of the user.
+	PhaseCancelled = OrderPhase("cancelled")
+	// PhaseExpired is the phase of an order that expired before it was completed.
+	PhaseExpired = OrderPhase("expired")
+)
+
+// IsFinal returns true if the order cannot leave the phase.
+func (p OrderPhase) IsFinal() bool {
+	switch p {
+	case PhaseComplete, PhaseFailed, PhaseCancelled, PhaseExpired:
+		return true
+	default:
+		return false
+	}
+}
+
+// hintProgress ranks the pending hint codes that show whether the client of the user has received the order, a
+// hint code ranked lower than a hint code the order has had is a regression. Other hint codes are not ranked.
+var hintProgress = map[string]int{
+	string(response.HintCodeOutstandingTransaction): 1,
+	string(response.HintCodeNoClient):               1,
+	string(response.HintCodeUserSign):               2,
+	string(response.HintCodeProcessing):             3,
+}
+
+// OrderTransition is a transition of an order into a phase.
+type OrderTransition struct {
+	// The phase the order entered.
+	Phase OrderPhase `json:"phase"`
+	// The hint code of the order in the phase, if any.
+	HintCode string `json:"hintCode,omitempty"`
+	// The time of the transition.
+	At time.Time `json:"at"`
+}
+
+// OrderState is the state machine of an order, which moves from created through pending with a changing hint code
+// to one of the final phases complete, failed, cancelled or expired.
+//
+// The transitions are validated and recorded with their time. Collect responses repeating the current phase and hint
+// code are not recorded. The state can be serialized as JSON for storage, the transitions are validated again when it
+// is decoded, as they are by Apply. An OrderState is not safe for concurrent use.
+type OrderState struct {
+	orderRef    string
+	transitions []OrderTransition
+}
+
+// orderStateJSON is the serialized form of an OrderState.
+type orderStateJSON struct {
+	OrderRef    string            `json:"orderRef"`
+	Transitions []OrderTransition `json:"transitions"`
+}
+
+// NewOrderState returns a new instance of 'OrderState' of an order created at the given time.
+func NewOrderState(orderRef string, createdAt time.Time) *OrderState {
+	return &OrderState{orderRef: orderRef, transitions: []OrderTransition{{Phase: PhaseCreated, At: createdAt}}}
+}
+
+// OrderRef returns the reference of the order.
+func (s *OrderState) OrderRef() string {
+	return s.orderRef
+}
+
+// Phase returns the current phase of the order.
+func (s *OrderState) Phase() OrderPhase {
+	return s.current().Phase
+}
+
+// HintCode returns the hint code of the current phase of the order.
+func (s *OrderState) HintCode() string {
+	return s.current().HintCode
+}
+
+// Transitions returns the transitions of the order, the first is the creation of the order.
+func (s *OrderState) Transitions() []OrderTransition {
+	return append([]OrderTransition(nil), s.transitions...)
+}
+
+// EnteredAt returns the time the order first entered the phase, false if it never did.
+func (s *OrderState) EnteredAt(phase OrderPhase) (time.Time, bool) {
+	for _, transition := range s.transitions {
+		if transition.Phase == phase {
+			return transition.At, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// Apply moves the order according to a collect response received at the given time.
+//
+// A failed order is moved to expired for the hint code expiredTransaction and to cancelled for the hint codes
+// userCancel and cancelled. It returns ErrInvalidTransition if the response is of another order or the order is in
+// another final phase, and ErrOrderRegression if the response reports an earlier phase or hint code than the
+// current.
+func (s *OrderState) Apply(collected *response.CollectResponse, at time.Time) error {
+	if collected.OrderRef != s.orderRef {
+		return fmt.Errorf("%w. collect response of order %s", ErrInvalidTransition, collected.OrderRef)
+	}
+
+	next := OrderTransition{Phase: collectedPhase(collected), HintCode: collected.HintCode, At: at}
+	current := s.current()
+
+	// Collect is repeated until the order is final, a repeated response is not a transition
+	if next.Phase == current.Phase && next.HintCode == current.HintCode {
+		return nil
+	}
+
+	if err := s.regression(next); err != nil {
+		return err
+	}
+
+	return s.transition(next)
+}
+
+// Cancel moves the order to cancelled, typically once it has been cancelled using BankIDClient.Cancel.
+func (s *OrderState) Cancel(at time.Time) error {
+	return s.transition(OrderTransition{Phase: PhaseCancelled, At: at})
+}
+
+// Expire moves the order to expired, typically once it has been pending longer than the BankID RP API keeps it.
+func (s *OrderState) Expire(at time.Time) error {
+	return s.transition(OrderTransition{Phase: PhaseExpired, At: at})
+}
+
+// MarshalJSON marshals the state into JSON.
+func (s *OrderState) MarshalJSON() ([]byte, error) {
+	return json.Marshal(orderStateJSON{OrderRef: s.orderRef, Transitions: s.transitions}) // nolint:wrapcheck
+}
+
+// UnmarshalJSON unmarshals the state from JSON, validating the transitions. It returns ErrInvalidTransition and
+// ErrOrderRegression as Apply does.
+func (s *OrderState) UnmarshalJSON(content []byte) error {
+	var decoded orderStateJSON
+	if err := json.Unmarshal(content, &decoded); err != nil {
+		return fmt.Errorf("unable to decode the order state. %w", err)
+	}
+
+	if len(decoded.Transitions) == 0 || decoded.Transitions[0].Phase != PhaseCreated {
+		return fmt.Errorf("%w. order state does not start with %s", ErrInvalidTransition, PhaseCreated)
+	}
+
+	replayed := NewOrderState(decoded.OrderRef, decoded.Transitions[0].At)
+
+	for _, transition := range decoded.Transitions[1:] {
+		if err := replayed.regression(transition); err != nil {
+			return err
+		}
+
+		if err := replayed.transition(transition); err != nil {
+			return err
+		}
+	}
+
+	*s = *replayed
+
+	return nil
+}
+
+// regression returns ErrOrderRegression if the transition moves the order back to pending from a final phase, or to a
+// hint code ranked lower than a hint code the order has had.
+func (s *OrderState) regression(next OrderTransition) error {
+	current := s.current()
+
+	if current.Phase.IsFinal() && next.Phase == PhasePending {
+		return fmt.Errorf("%w. %s to %s", ErrOrderRegression, current.Phase, next.Phase)
+	}
+
+	if rank, ok := hintProgress[next.HintCode]; ok && next.Phase == PhasePending && rank < s.progress() {
+		return fmt.Errorf("%w. hint code %s to %s", ErrOrderRegression, current.HintCode, next.HintCode)
+	}
+
+	return nil
+}
+
+// transition validates and records a transition.
+func (s *OrderState) transition(next OrderTransition) error {
+	current := s.current()
+
+	if current.Phase.IsFinal() {
+		if next.Phase == current.Phase {
+			return nil
+		}
+
+		return fmt.Errorf("%w. %s to %s", ErrInvalidTransition, current.Phase, next.Phase)
+	}
+
+	switch next.Phase {
+	case PhasePending, PhaseComplete, PhaseFailed, PhaseCancelled, PhaseExpired:
+	default:
+		return fmt.Errorf("%w. %s to %s", ErrInvalidTransition, current.Phase, next.Phase)
+	}
+
+	if next.At.Before(current.At) {
+		return fmt.Errorf("%w. %s at %s is before %s", ErrInvalidTransition, next.Phase, next.At, current.At)
+	}
+
+	s.transitions = append(s.transitions, next)
+
+	return nil
+}
+
+// progress returns the highest rank of the hint codes of the order, see hintProgress.
+func (s *OrderState) progress() int {
+	progress := 0
+
+	for _, transition := range s.transitions {
+		progress = max(progress, hintProgress[transition.HintCode])
+	}
+
+	return progress
+}
+
+func (s *OrderState) current() OrderTransition {
+	return s.transitions[len(s.transitions)-1]
+}
+
+// collectedPhase returns the phase of the order reported by the collect response.
+func collectedPhase(collected *response.CollectResponse) OrderPhase {
+	switch collected.Status {
+	case response.StatusPending:
+		return PhasePending
+	case response.StatusComplete:
+		return PhaseComplete
+	default:
+		switch response.HintCode(collected.HintCode) {
+		case response.HintCodeExpiredTransaction:
+			return PhaseExpired
+		case response.HintCodeUserCancel, response.HintCodeCancelled:
+			return PhaseCancelled
+		default:
+			return PhaseFailed
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package pkg
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/e-identification/bankid-go/pkg/response"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrderState(t *testing.T) {
+	created := time.Now()
+	state := NewOrderState("order", created)
+
+	assert.Equal(t, PhaseCreated, state.Phase())
+
+	assert.NoError(t, state.Apply(collected("order", response.StatusPending, "outstandingTransaction"), created))
+	assert.NoError(t, state.Apply(collected("order", response.StatusPending, "outstandingTransaction"), created))
+	assert.NoError(t, state.Apply(collected("order", response.StatusPending, "userSign"), created.Add(time.Second)))
+	assert.Equal(t, PhasePending, state.Phase())
+	assert.Equal(t, "userSign", state.HintCode())
+
+	assert.NoError(t, state.Apply(collected("order", response.StatusComplete, ""), created.Add(2*time.Second)))
+	assert.NoError(t, state.Apply(collected("order", response.StatusComplete, ""), created.Add(3*time.Second)))
+	assert.Equal(t, PhaseComplete, state.Phase())
+	assert.Len(t, state.Transitions(), 4)
+
+	completedAt, ok := state.EnteredAt(PhaseComplete)
+	assert.True(t, ok)
+	assert.Equal(t, created.Add(2*time.Second), completedAt)
+
+	_, ok = state.EnteredAt(PhaseFailed)
+	assert.False(t, ok)
+
+	assert.ErrorIs(t, state.Cancel(created.Add(4*time.Second)), ErrInvalidTransition)
+	assert.ErrorIs(t, state.Apply(collected("order", response.StatusPending, "userSign"), created), ErrOrderRegression)
+	assert.ErrorIs(t, state.Apply(collected("other", response.StatusComplete, ""), created), ErrInvalidTransition)
+}
+
+func TestOrderStateFailedPhases(t *testing.T) {
+	now := time.Now()
+
+	tests := map[string]OrderPhase{
+		"expiredTransaction": PhaseExpired,
+		"userCancel":         PhaseCancelled,
+		"cancelled":          PhaseCancelled,
+		"startFailed":        PhaseFailed,
+	}
+
+	for hintCode, phase := range tests {
+		state := NewOrderState("order", now)
+
+		assert.NoError(t, state.Apply(collected("order", response.StatusFailed, hintCode), now))
+		assert.Equal(t, phase, state.Phase(), hintCode)
+		assert.True(t, state.Phase().IsFinal())
+	}
+}
+
+func TestOrderStateHintCodeRegression(t *testing.T) {
+	now := time.Now()
+	state := NewOrderState("order", now)
+
+	assert.NoError(t, state.Apply(collected("order", response.StatusPending, "userSign"), now))
+	assert.NoError(t, state.Apply(collected("order", response.StatusPending, "userMrtd"), now))
+	assert.ErrorIs(t, state.Apply(collected("order", response.StatusPending, "noClient"), now), ErrOrderRegression)
+	assert.ErrorIs(t, state.Expire(now.Add(-time.Second)), ErrInvalidTransition)
+	assert.NoError(t, state.Expire(now))
+	assert.Equal(t, PhaseExpired, state.Phase())
+}
+
+func TestOrderStateJSON(t *testing.T) {
+	now := time.Now().UTC().Round(0)
+	state := NewOrderState("order", now)
+
+	_ = state.Apply(collected("order", response.StatusPending, "userSign"), now.Add(time.Second))
+	_ = state.Cancel(now.Add(2 * time.Second))
+
+	content, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := &OrderState{}
+	if err := json.Unmarshal(content, decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, "order", decoded.OrderRef())
+	assert.Equal(t, PhaseCancelled, decoded.Phase())
+	assert.Equal(t, state.Transitions(), decoded.Transitions())
+
+	invalid := `{"orderRef":"order","transitions":[{"phase":"created"},{"phase":"complete"},{"phase":"failed"}]}`
+	assert.ErrorIs(t, json.Unmarshal([]byte(invalid), &OrderState{}), ErrInvalidTransition)
+
+	final := `{"orderRef":"order","transitions":[{"phase":"created"},{"phase":"complete"},{"phase":"pending"}]}`
+	assert.ErrorIs(t, json.Unmarshal([]byte(final), &OrderState{}), ErrOrderRegression)
+
+	regressed := `{"orderRef":"order","transitions":[{"phase":"created"},{"phase":"pending","hintCode":"userSign"},` +
+		`{"phase":"pending","hintCode":"outstandingTransaction"}]}`
+	assert.ErrorIs(t, json.Unmarshal([]byte(regressed), &OrderState{}), ErrOrderRegression)
+}
+
+func collected(orderRef string, status response.Status, hintCode string) *response.CollectResponse {
+	return &response.CollectResponse{OrderRef: orderRef, Status: status, HintCode: hintCode}
+}
@@ -0,0 +1,138 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/e-identification/bankid-go/pkg/payload"
+	"github.com/e-identification/bankid-go/pkg/response"
+)
+
+var (
+	// ErrNotAuthenticated is returned by NewStepUp when the authentication is not complete or identifies no user.
+	ErrNotAuthenticated = errors.New("authentication is not complete")
+	// ErrNotAuthenticatedUser is wrapped by the ValidationError returned by StepUp.Sign when the payload requires the
+	// personal number of another user than the authenticated user.
+	ErrNotAuthenticatedUser = errors.New("personal number is not the authenticated user")
+	// ErrUnknownStepUpOrder is returned by StepUp.Collect when the order was not started by the step-up.
+	ErrUnknownStepUpOrder = errors.New("order was not started by the step-up")
+)
+
+// StepUp binds sign orders to a completed authentication, making sure that the authenticated user is the user that
+// signs.
+//
+// The sign orders are started requiring the personal number of the authenticated user, and the signer of each
+// complete order is verified against it, which protects against a payload that was built without the requirement.
+// Only the orders started by Sign are collected, until they are no longer pending.
+type StepUp struct {
+	client BankID
+	user   response.User
+
+	mutex  sync.Mutex
+	orders map[string]struct{}
+}
+
+// NewStepUp returns a new instance of 'StepUp' binding the sign orders to the authentication, which is the collect
+// response of a complete authentication order. It returns ErrNotAuthenticated if the authentication is not complete.
+func NewStepUp(client BankID, authentication *response.CollectResponse) (*StepUp, error) {
+	if authentication == nil || !authentication.IsComplete() ||
+		authentication.CompletionData.User.PersonalNumber == "" {
+		return nil, ErrNotAuthenticated
+	}
+
+	return &StepUp{client: client, user: authentication.CompletionData.User, orders: map[string]struct{}{}}, nil
+}
+
+// User returns the authenticated user.
+func (s *StepUp) User() response.User {
+	return s.user
+}
+
+// Sign - Initiates a sign order that only the authenticated user can sign.
+//
+// The personal number of the authenticated user is required, the payload is not modified. It returns a
+// ValidationError wrapping ErrNotAuthenticatedUser if the payload requires another personal number, see
+// BankIDClient.Sign for the other errors.
+func (s *StepUp) Sign(ctx context.Context, signPayload *payload.SignPayload) (*response.SignResponse, error) {
+	bound := *signPayload
+
+	requirement := payload.Requirement{}
+	if signPayload.Requirement != nil {
+		requirement = *signPayload.Requirement
+	}
+
+	if requirement.PersonalNumber != "" && requirement.PersonalNumber != s.user.PersonalNumber {
+		return nil, NewValidationError("PersonalNumber", requirement.PersonalNumber, ErrNotAuthenticatedUser)
+	}
+
+	requirement.PersonalNumber = s.user.PersonalNumber
+	bound.Requirement = &requirement
+
+	started, err := s.client.Sign(ctx, &bound)
+	if err != nil {
+		return nil, err // nolint:wrapcheck
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.orders[started.OrderRef] = struct{}{}
+
+	return started, nil
+}
+
+// Collect - Collects a sign order of the step-up, verifying the signer once the order is complete.
+//
+// It returns ErrUnknownStepUpOrder if the order was not started by Sign, or is no longer pending, and
+// SignerMismatchError if the order was signed by another user than the authenticated user, see BankIDClient.Collect
+// for the other errors.
+func (s *StepUp) Collect(
+	ctx context.Context,
+	collectPayload *payload.CollectPayload,
+) (*response.CollectResponse, error) {
+	if !s.started(collectPayload.OrderRef) {
+		return nil, ErrUnknownStepUpOrder
+	}
+
+	collected, err := s.client.Collect(ctx, collectPayload)
+	if err != nil {
+		return nil, err // nolint:wrapcheck
+	}
+
+	if !collected.IsPending() {
+		s.mutex.Lock()
+		delete(s.orders, collectPayload.OrderRef)
+		s.mutex.Unlock()
+	}
+
+	if err := s.Verify(collected); err != nil {
+		return nil, err
+	}
+
+	return collected, nil
+}
+
+// started returns true if the order was started by Sign and is pending.
+func (s *StepUp) started(orderRef string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, ok := s.orders[orderRef]
+
+	return ok
+}
+
+// Verify returns SignerMismatchError if the collect response is of a complete order signed by another user than the
+// authenticated user.
+func (s *StepUp) Verify(collected *response.CollectResponse) error {
+	if !collected.IsComplete() {
+		return nil
+	}
+
+	if signer := collected.CompletionData.User.PersonalNumber; signer != s.user.PersonalNumber {
+		return &SignerMismatchError{Expected: s.user.PersonalNumber, Actual: signer}
+	}
+
+	return nil
+}
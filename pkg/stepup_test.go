@@ -0,0 +1,137 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/e-identification/bankid-go/pkg/payload"
+	"github.com/e-identification/bankid-go/pkg/response"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStepUp(t *testing.T) {
+	var (
+		signer = "199001011234"
+		status = "complete"
+		orders = 0
+	)
+
+	bankID, teardown := testBankID(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case "/rp/v6.0/sign":
+			var body struct {
+				Requirement payload.Requirement `json:"requirement"`
+			}
+
+			assert.NoError(t, json.NewDecoder(request.Body).Decode(&body))
+			assert.Equal(t, "199001011234", body.Requirement.PersonalNumber)
+			assert.True(t, body.Requirement.PinCode)
+
+			orders++
+			stringToResponseHandler(t, fmt.Sprintf(`{"orderRef":"sign-%d"}`, orders))(writer, request)
+		default:
+			var collectPayload payload.CollectPayload
+			assert.NoError(t, json.NewDecoder(request.Body).Decode(&collectPayload))
+
+			body := fmt.Sprintf(`{"orderRef":%q,"status":%q,"completionData":{"user":{"personalNumber":%q}}}`,
+				collectPayload.OrderRef, status, signer)
+			stringToResponseHandler(t, body)(writer, request)
+		}
+	})
+	defer teardown()
+
+	ctx := context.Background()
+
+	stepUp, err := NewStepUp(bankID, authenticated("199001011234"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signPayload := &payload.SignPayload{
+		EndUserIP: "192.168.1.1", UserVisibleData: "dGVzdA==", Requirement: &payload.Requirement{PinCode: true},
+	}
+
+	_, err = stepUp.Sign(ctx, signPayload)
+	assert.NoError(t, err)
+	assert.Empty(t, signPayload.Requirement.PersonalNumber)
+
+	collected, err := stepUp.Collect(ctx, &payload.CollectPayload{OrderRef: "sign-1"})
+	assert.NoError(t, err)
+	assert.True(t, collected.IsComplete())
+
+	// The order is forgotten once it is no longer pending
+	_, err = stepUp.Collect(ctx, &payload.CollectPayload{OrderRef: "sign-1"})
+	assert.ErrorIs(t, err, ErrUnknownStepUpOrder)
+
+	_, err = stepUp.Sign(ctx, signPayload)
+	assert.NoError(t, err)
+
+	status = "pending"
+
+	_, err = stepUp.Collect(ctx, &payload.CollectPayload{OrderRef: "sign-2"})
+	assert.NoError(t, err)
+
+	status, signer = "complete", "199001015678"
+
+	_, err = stepUp.Collect(ctx, &payload.CollectPayload{OrderRef: "sign-2"})
+
+	var mismatch *SignerMismatchError
+	if assert.True(t, errors.As(err, &mismatch)) {
+		assert.Equal(t, "199001011234", mismatch.Expected)
+		assert.Equal(t, "199001015678", mismatch.Actual)
+	}
+}
+
+func TestStepUpCollectsOnlyItsOrders(t *testing.T) {
+	stepUp, err := NewStepUp(BankIDClient{}, authenticated("199001011234"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The order of the authentication itself is complete and signed by the authenticated user
+	_, err = stepUp.Collect(context.Background(), &payload.CollectPayload{OrderRef: "authenticate"})
+	assert.ErrorIs(t, err, ErrUnknownStepUpOrder)
+}
+
+func TestStepUpRejectsAnotherUser(t *testing.T) {
+	stepUp, err := NewStepUp(BankIDClient{}, authenticated("199001011234"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = stepUp.Sign(context.Background(), &payload.SignPayload{
+		EndUserIP: "192.168.1.1", UserVisibleData: "dGVzdA==",
+		Requirement: &payload.Requirement{PersonalNumber: "199001015678"},
+	})
+
+	var validationError *ValidationError
+	if assert.True(t, errors.As(err, &validationError)) {
+		assert.Equal(t, "PersonalNumber", validationError.Field)
+		assert.Equal(t, "199001015678", validationError.RejectedValue)
+	}
+
+	assert.ErrorIs(t, err, ErrNotAuthenticatedUser)
+	assert.False(t, IsTemporary(err))
+
+	assert.NoError(t, stepUp.Verify(&response.CollectResponse{Status: response.StatusPending}))
+}
+
+func TestStepUpRequiresCompleteAuthentication(t *testing.T) {
+	_, err := NewStepUp(BankIDClient{}, &response.CollectResponse{Status: response.StatusPending})
+	assert.ErrorIs(t, err, ErrNotAuthenticated)
+
+	_, err = NewStepUp(BankIDClient{}, nil)
+	assert.ErrorIs(t, err, ErrNotAuthenticated)
+}
+
+func authenticated(personalNumber string) *response.CollectResponse {
+	return &response.CollectResponse{
+		OrderRef: "authenticate", Status: response.StatusComplete,
+		CompletionData: response.CompletionData{User: response.User{PersonalNumber: personalNumber}},
+	}
+}
@@ -0,0 +1,78 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// TransportStats holds the statistics of the connections to the BankID RP API.
+type TransportStats struct {
+	// The number of calls that got a connection.
+	Requests int64
+	// The number of calls that established a new connection, and thereby paid for a TLS handshake.
+	NewConnections int64
+	// The number of calls that reused an idle or, with HTTP/2, a shared connection.
+	ReusedConnections int64
+	// The number of completed TLS handshakes.
+	Handshakes int64
+	// The number of TLS handshakes that resumed a cached session.
+	ResumedHandshakes int64
+	// The number of failed TLS handshakes.
+	FailedHandshakes int64
+}
+
+// WarmUp establishes the connections to the BankID RP API ahead of the first call, which would otherwise pay for the
+// mTLS handshake.
+//
+// The number of connections is given by the WarmUpConnections of the transport policy. Each connection is warmed up
+// by collecting a non-existing order and is then kept idle for the IdleTimeout of the transport policy. WarmUp is
+// typically called on startup, before the service reports ready.
+func (b BankIDClient) WarmUp(ctx context.Context) error {
+	connections := b.configuration.TransportPolicy.WithDefaults().WarmUpConnections
+
+	var (
+		waitGroup sync.WaitGroup
+		mutex     sync.Mutex
+		errs      []error
+	)
+
+	for range connections {
+		waitGroup.Add(1)
+
+		go func() {
+			defer waitGroup.Done()
+
+			if result := b.probe(ctx); result.err != nil {
+				mutex.Lock()
+				defer mutex.Unlock()
+
+				errs = append(errs, result.err)
+			}
+		}()
+	}
+
+	waitGroup.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("unable to warm up the connections. %w", err)
+	}
+
+	return nil
+}
+
+// TransportStats returns the statistics of the connections to the BankID RP API, which tell whether calls reuse the
+// warmed up connections.
+func (b BankIDClient) TransportStats() TransportStats {
+	stats := b.client.Stats()
+
+	return TransportStats{
+		Requests:          stats.Requests,
+		NewConnections:    stats.NewConnections,
+		ReusedConnections: stats.ReusedConnections,
+		Handshakes:        stats.Handshakes,
+		ResumedHandshakes: stats.ResumedHandshakes,
+		FailedHandshakes:  stats.FailedHandshakes,
+	}
+}